@@ -0,0 +1,165 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// note identifies a note of a channel.
+type note struct {
+	channel uint8
+	key     uint8
+}
+
+// noteOf returns the note which msg presses or releases, whether msg presses it and whether msg is a note on or a note
+// off at all.
+// Note ons with a velocity of 0 release the note, as many devices send those instead of note offs.
+func noteOf(msg midi.Message) (n note, on bool, ok bool) {
+	raw := msg.Raw()
+	if len(raw) < 3 {
+		return
+	}
+	n = note{raw[0] & 0x0f, raw[1]}
+	switch raw[0] & 0xf0 {
+	case 0x80:
+		return n, false, true
+	case 0x90:
+		return n, raw[2] != 0, true
+	default:
+		return
+	}
+}
+
+// trackNotes keeps track of the notes which are held, as pressed and released by msg at now.
+func (e *engine) trackNotes(msg midi.Message, now time.Time) {
+	n, on, ok := noteOf(msg)
+	switch {
+	case !ok:
+	case on:
+		e.held[n] = now
+		delete(e.heldFor, n)
+	default:
+		if pressed, ok := e.held[n]; ok {
+			e.heldFor[n] = now.Sub(pressed)
+			delete(e.held, n)
+		}
+	}
+}
+
+// chordCompletes reports whether msg completes c.
+func (e *engine) chordCompletes(c matcher.ChordMatcher, msg midi.Message) bool {
+	n, on, ok := noteOf(msg)
+	if !ok || !on || !chordHasKey(c, n.key) {
+		return false
+	}
+
+	first, last := e.held[n], e.held[n]
+	for _, key := range c.Notes {
+		pressed, ok := e.held[note{n.channel, uint8(key)}]
+		if !ok {
+			return false
+		}
+		if pressed.Before(first) {
+			first = pressed
+		}
+		if pressed.After(last) {
+			last = pressed
+		}
+	}
+	return c.Within == 0 || last.Sub(first) <= c.Within
+}
+
+func chordHasKey(c matcher.ChordMatcher, key uint8) bool {
+	for _, k := range c.Notes {
+		if k == int64(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferredNoteOn is a note on of a note of an exclusive chord, which is evaluated once it is clear it does not take
+// part in completing the chord.
+type deferredNoteOn struct {
+	msg   midi.Message
+	note  note
+	timer *time.Timer
+}
+
+// deferExclusiveChordNote handles a note on msg of a note of the exclusive chords of the map.
+//
+// If msg completes some exclusive chord, the deferred note ons of the chord are dropped and msg is evaluated against
+// the mappings with chord matchers only. If it does not, the evaluation of msg is deferred until the longest Within of
+// the exclusive chords having the note has passed.
+// deferExclusiveChordNote reports whether it handled msg, that is whether msg is a note on of such a note at all.
+func (e *engine) deferExclusiveChordNote(msg midi.Message) (handled bool, err error) {
+	n, on, ok := noteOf(msg)
+	if !ok || !on {
+		return false, nil
+	}
+
+	var within time.Duration
+	for _, c := range e.exclusiveChords {
+		if !chordHasKey(c, n.key) {
+			continue
+		}
+		if e.chordCompletes(c, msg) {
+			for _, key := range c.Notes {
+				e.dropDeferredNoteOn(note{n.channel, uint8(key)})
+			}
+			return true, e.evaluateMessage(msg, hasChordMatcher)
+		}
+		if c.Within > within {
+			within = c.Within
+		}
+	}
+	if within == 0 {
+		return false, nil
+	}
+
+	d := &deferredNoteOn{msg: msg, note: n}
+	d.timer = time.AfterFunc(within, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.dropDeferredNoteOn(n) == d {
+			e.report(e.evaluateMessage(msg, nil))
+		}
+	})
+	e.deferred = append(e.deferred, d)
+	return true, nil
+}
+
+// flushDeferredNoteOn evaluates the deferred note on of n right away, if there is one.
+// It must be called before evaluating a release of n, so that the release is not evaluated before the note on.
+func (e *engine) flushDeferredNoteOn(n note) error {
+	d := e.dropDeferredNoteOn(n)
+	if d == nil {
+		return nil
+	}
+	return e.evaluateMessage(d.msg, nil)
+}
+
+// dropDeferredNoteOn stops deferring the deferred note on of n without evaluating it, and returns it.
+// If there is no deferred note on of n, dropDeferredNoteOn returns nil.
+func (e *engine) dropDeferredNoteOn(n note) *deferredNoteOn {
+	for i, d := range e.deferred {
+		if d.note == n {
+			d.timer.Stop()
+			e.deferred = append(e.deferred[:i], e.deferred[i+1:]...)
+			return d
+		}
+	}
+	return nil
+}
+
+// hasChordMatcher reports whether m is or is composed of a chord matcher.
+func hasChordMatcher(m matcher.Matcher) (has bool) {
+	matcher.Walk(m, func(n matcher.Matcher) {
+		if _, ok := n.(matcher.ChordMatcher); ok {
+			has = true
+		}
+	})
+	return
+}
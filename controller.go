@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// controller identifies a controller of a channel.
+type controller struct {
+	channel uint8
+	number  uint8
+}
+
+// controllerOf returns the controller changed by msg and its new value, if msg is a control change message at all.
+func controllerOf(msg midi.Message) (c controller, value int64, ok bool) {
+	raw := msg.Raw()
+	if len(raw) < 3 || raw[0]&0xf0 != 0xb0 {
+		return
+	}
+	return controller{raw[0] & 0x0f, raw[1]}, int64(raw[2]), true
+}
+
+// trackControllers keeps track of the values of the controllers, as changed by msg.
+func (e *engine) trackControllers(msg midi.Message) {
+	c, value, ok := controllerOf(msg)
+	if !ok {
+		return
+	}
+	if previous, ok := e.controllers[c]; ok {
+		e.previousControllers[c] = previous
+	} else {
+		delete(e.previousControllers, c)
+	}
+	e.controllers[c] = value
+}
+
+// controllerChangeValue returns the value of o for msg, and whether o has a value for msg at all.
+func (e *engine) controllerChangeValue(o matcher.ControllerChange, msg midi.Message) (int64, bool) {
+	c, value, ok := controllerOf(msg)
+	if !ok {
+		return 0, false
+	}
+	if o == matcher.Steps {
+		encoder, ok := e.m.Encoder(int64(c.number))
+		if !ok {
+			return 0, false
+		}
+		return encoder.Steps(value), true
+	}
+	previous, ok := e.previousControllers[c]
+	if !ok {
+		return 0, false
+	}
+	switch o {
+	case matcher.PreviousData2:
+		return previous, true
+	case matcher.Delta:
+		return value - previous, true
+	case matcher.Direction:
+		switch {
+		case value < previous:
+			return -1, true
+		case value > previous:
+			return 1, true
+		default:
+			return 0, true
+		}
+	default:
+		panic("unreachable")
+	}
+}
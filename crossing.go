@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// crossingKey identifies the state of a crossing matcher for a controller.
+type crossingKey struct {
+	controller controller
+	crossing   matcher.CrossingMatcher
+}
+
+// crossingState is the state of a crossing matcher for a controller.
+type crossingState struct {
+	// disarmed is whether the controller has crossed the threshold without crossing back to the rearm level since.
+	disarmed bool
+	// crossed is whether the last control change message of the controller crossed the threshold.
+	crossed bool
+}
+
+// trackCrossings keeps track of which thresholds of the crossing matchers of the map msg crosses, if it is a control
+// change message.
+// This is done for every message, rather than when the crossing matchers are evaluated, as they may not be evaluated for
+// every message.
+func (e *engine) trackCrossings(msg midi.Message) {
+	c, value, ok := controllerOf(msg)
+	if !ok {
+		return
+	}
+	for _, x := range e.crossings {
+		k := crossingKey{c, x}
+		s, ok := e.crossingStates[k]
+		if !ok {
+			s = &crossingState{}
+			e.crossingStates[k] = s
+		}
+		s.crossed = false
+		// Multiplying by the direction turns crossing down into crossing up.
+		d := int64(x.Direction)
+		switch {
+		case !s.disarmed && value*d >= x.Threshold*d:
+			s.crossed = true
+			s.disarmed = true
+		case s.disarmed && value*d <= x.Rearm*d:
+			s.disarmed = false
+		}
+	}
+}
+
+// crossingMatches reports whether x matches msg.
+func (e *engine) crossingMatches(x matcher.CrossingMatcher, msg midi.Message) bool {
+	c, _, ok := controllerOf(msg)
+	if !ok {
+		return false
+	}
+	s, ok := e.crossingStates[crossingKey{c, x}]
+	return ok && s.crossed
+}
+
+func (e *engine) addCrossing(x matcher.CrossingMatcher) {
+	for _, y := range e.crossings {
+		if y == x {
+			return
+		}
+	}
+	e.crossings = append(e.crossings, x)
+}
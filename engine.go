@@ -0,0 +1,594 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fossegrim/midimap/lang"
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/directive"
+	"github.com/fossegrim/midimap/lang/mapping"
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// engine maps MIDI messages to actions as described by a map, keeping track of the state which the map depends on.
+//
+// An engine is safe for concurrent use, as some of its work is done by timers.
+type engine struct {
+	// mu guards every field below it.
+	mu sync.Mutex
+	kb keyboard
+	m  lang.Map
+	// layers is the stack of active layers, as indices into m.Layers. The base layer is always at the bottom.
+	layers []int
+	// holds are the layers activated by layer hold actions, which are deactivated once their message is released.
+	holds []layerHold
+	// variables are the values of the variables of m by name.
+	variables map[string]int64
+	// held are the times at which the notes which are held were pressed.
+	held map[note]time.Time
+	// heldFor are how long the notes which have been released were held the last time they were.
+	heldFor map[note]time.Duration
+	// controllers are the values of the controllers which have been changed, and previousControllers the values they
+	// had before they were last changed, if they were changed before.
+	controllers         map[controller]int64
+	previousControllers map[controller]int64
+	// crossings are the crossing matchers of m, and crossingStates their states for each controller.
+	crossings      []matcher.CrossingMatcher
+	crossingStates map[crossingKey]*crossingState
+	// rates are the rate matchers of m, except for those counting the same messages as others, rateHits the times of
+	// the messages counted for each of them, and rateTimer the timer scheduled by scheduleRateExpiry.
+	rates     []matcher.RateMatcher
+	rateHits  [][]time.Time
+	rateTimer *time.Timer
+	// cursors are the states of the list actions of m.
+	cursors map[listKey]*listCursor
+	// macros performs the actions of the mappings of m which wait.
+	macros *macroRunner
+	// pressed are the keys which are held for a hold duration, along with the timers releasing them.
+	pressed map[action.KeyAction]*time.Timer
+	// keyHolds are the keys held by key actions which hold them.
+	keyHolds []keyHold
+	// latched are the keys latched by toggle key actions, in the order they were latched.
+	latched []action.KeyAction
+	// verbose is whether changes to the latched keys are described.
+	verbose bool
+	// wheel is the virtual mouse wheel scrolled by scroll actions, or nil if none has been performed.
+	wheel *uinputDevice
+	// gamepad is the virtual gamepad of button and axis actions, or nil if none has been performed, and buttons are its
+	// buttons which are held for a hold duration, along with the timers releasing them.
+	gamepad *uinputDevice
+	buttons map[int]*time.Timer
+	// out is the output port of send actions and feedback, or nil if there is none, and feedback are whether the
+	// feedback of m was last sent as on, or nil if it has not been sent since m was loaded.
+	out      midi.Out
+	feedback []bool
+	// holdingThresholds are the durations which holding operands of m are compared to.
+	holdingThresholds []time.Duration
+	// holding is the value of holding operands while a note which has been held for a holding threshold is evaluated,
+	// and 0 otherwise.
+	holding time.Duration
+	// holdingTimers are the timers scheduled by scheduleHolding for the notes which are held.
+	holdingTimers map[note][]*time.Timer
+	// tapWindows are the durations which taps matchers of m count taps within.
+	tapWindows []time.Duration
+	// taps are the taps counted for each note and duration.
+	taps map[tapKey]*tapCounter
+	// expiredTaps identifies the taps whose duration has passed without another tap while their last tap is evaluated,
+	// and is nil otherwise.
+	expiredTaps *tapKey
+	// matched, if not nil, is called with every message which a mapping matches, before its actions are performed.
+	matched func(msg midi.Message)
+	// exclusiveChords are the exclusive chords of m.
+	exclusiveChords []matcher.ChordMatcher
+	// deferred are the note ons of notes of exclusive chords which are yet to be evaluated.
+	deferred []*deferredNoteOn
+	// sequences are the mappings of m which are sequences.
+	sequences []*mapping.Mapping
+	// progress are the partial matches of each of the sequences.
+	progress map[*mapping.Mapping][]sequenceProgress
+}
+
+// keyHold is a key held by key, a key action of mapping, in response to msg.
+type keyHold struct {
+	mapping *mapping.Mapping
+	key     action.KeyAction
+	msg     midi.Message
+}
+
+// layerHold is a layer activated by a layer hold action in response to msg.
+type layerHold struct {
+	layer int
+	msg   midi.Message
+}
+
+func newEngine(kb keyboard, m lang.Map) *engine {
+	e := &engine{kb: kb, pressed: make(map[action.KeyAction]*time.Timer)}
+	e.macros = newMacroRunner(e)
+	e.openDevices(m)
+	e.load(m)
+	return e
+}
+
+// openDevices creates the virtual input devices which the actions of m use, unless they have been created already.
+// Creating a device takes a while, so it is done when a map is loaded rather than when the device is first used, and
+// without e.mu held, so that neither the messages arriving nor the timers firing meanwhile are delayed.
+// It must not be called with e.mu held.
+func (e *engine) openDevices(m lang.Map) {
+	e.report(e.openWheel(m))
+	e.report(e.openGamepad(m))
+}
+
+// usesAction reports whether any action of m, or any action it is composed of, satisfies f.
+func usesAction(m lang.Map, f func(action.Action) bool) (uses bool) {
+	for _, l := range m.Layers {
+		for _, mp := range l.Mappings {
+			for _, a := range mp.Actions {
+				action.Walk(a, func(b action.Action) {
+					if f(b) {
+						uses = true
+					}
+				})
+			}
+		}
+	}
+	return
+}
+
+// close stops performing macros and evaluating holding thresholds, releases every held key and destroys the virtual
+// input devices, as is done before exiting.
+func (e *engine) close() {
+	e.macros.close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopAllHolding()
+	e.report(e.releaseAllKeys())
+	e.clearFeedback()
+	if e.wheel != nil {
+		e.report(e.wheel.close())
+		e.wheel = nil
+	}
+	if e.gamepad != nil {
+		for _, t := range e.buttons {
+			t.Stop()
+		}
+		e.report(e.gamepad.close())
+		e.gamepad = nil
+	}
+}
+
+// reload replaces the map of e by m, starting over with every layer but the base layer inactive and every variable
+// having its initial value.
+func (e *engine) reload(m lang.Map) {
+	e.openDevices(m)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.load(m)
+}
+
+// load makes m the map of e, resetting the state of e and sending its feedback.
+func (e *engine) load(m lang.Map) {
+	// The macros and keys of the previous map would go on otherwise.
+	e.macros.cancel()
+	e.report(e.releaseAllKeys())
+	e.clearFeedback()
+	if e.rateTimer != nil {
+		e.rateTimer.Stop()
+		e.rateTimer = nil
+	}
+	e.m = m
+	e.layers = []int{0}
+	e.holds = nil
+	e.variables = make(map[string]int64)
+	e.held = make(map[note]time.Time)
+	e.heldFor = make(map[note]time.Duration)
+	e.controllers = make(map[controller]int64)
+	e.previousControllers = make(map[controller]int64)
+	e.crossings = nil
+	e.crossingStates = make(map[crossingKey]*crossingState)
+	e.rates = nil
+	e.rateHits = nil
+	e.cursors = make(map[listKey]*listCursor)
+	e.holdingThresholds = nil
+	e.stopAllHolding()
+	e.tapWindows = nil
+	e.taps = make(map[tapKey]*tapCounter)
+	e.exclusiveChords = nil
+	e.deferred = nil
+	e.sequences = nil
+	e.progress = make(map[*mapping.Mapping][]sequenceProgress)
+	for _, v := range m.Variables {
+		e.variables[v.Name] = v.Value
+	}
+	for _, l := range m.Layers {
+		for i, mp := range l.Mappings {
+			for _, mm := range mp.Matchers() {
+				matcher.Walk(mm, func(n matcher.Matcher) {
+					if c, ok := n.(matcher.ChordMatcher); ok && c.Exclusive {
+						e.exclusiveChords = append(e.exclusiveChords, c)
+					}
+					if d, ok := holdingThreshold(n); ok {
+						e.addHoldingThreshold(d)
+					}
+					if t, ok := n.(matcher.TapsMatcher); ok {
+						e.addTapWindow(t.Within)
+					}
+					if x, ok := n.(matcher.CrossingMatcher); ok {
+						e.addCrossing(x)
+					}
+					if r, ok := n.(matcher.RateMatcher); ok {
+						e.addRate(r)
+					}
+				})
+			}
+			if mp.Sequence != nil {
+				e.sequences = append(e.sequences, &l.Mappings[i])
+			}
+		}
+	}
+	e.updateFeedback()
+}
+
+// mapMIDIMessageToKeyPress performs the actions of the mappings of the active layers which match msg.
+//
+// The active layers are evaluated from the most to the least recently activated, and the mappings of a layer in order.
+// Whether evaluation continues after a matching mapping is decided by the Flow of the mapping, or if it has none, by the
+// evaluation mode of the map.
+func (e *engine) mapMIDIMessageToKeyPress(msg midi.Message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if n, on, ok := noteOf(msg); ok && !on {
+		if err := e.flushDeferredNoteOn(n); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	e.track(msg, now)
+	e.releaseLayers(msg)
+	e.updateFeedback()
+	if err := e.releaseKeys(msg); err != nil {
+		return err
+	}
+	defer e.scheduleRateExpiry(now)
+	e.scheduleHolding(msg)
+	e.scheduleTapsExpiry(msg)
+
+	if handled, err := e.deferExclusiveChordNote(msg); handled {
+		return err
+	}
+	return e.evaluateMessage(msg, nil)
+}
+
+// track keeps track of the state which matchers depend on, as changed by msg at now.
+func (e *engine) track(msg midi.Message, now time.Time) {
+	e.trackNotes(msg, now)
+	e.trackControllers(msg)
+	e.trackCrossings(msg)
+	e.trackRates(msg, now)
+	e.trackTaps(msg, now)
+}
+
+// evaluate performs the actions of the mappings of the active layers which match msg, as described by
+// mapMIDIMessageToKeyPress.
+// If filter is not nil, only the mappings with a matcher for which filter returns true are evaluated.
+func (e *engine) evaluate(msg midi.Message, filter func(matcher.Matcher) bool) (err error) {
+	now := time.Now()
+	// The actions may change the active layers, which must not affect which layers this message is evaluated against.
+	layers := append([]int(nil), e.layers...)
+	for i := len(layers) - 1; i >= 0; i-- {
+		mappings := e.m.Layers[layers[i]].Mappings
+		for j := range mappings {
+			mp := &mappings[j]
+			if filter != nil && !filter(mp.Matcher) || !e.matcherMatchesMessage(mp.Matcher, msg) {
+				continue
+			}
+			if mp.Sequence != nil && !e.completeSequence(mp, now) {
+				continue
+			}
+			if e.matched != nil {
+				e.matched(msg)
+			}
+			err = e.performActions(mp, msg)
+			if err != nil {
+				return
+			}
+			if stopsEvaluation(e.m.Evaluation, mp.Flow) {
+				return
+			}
+		}
+	}
+	return
+}
+
+// evaluateMessage is like evaluate, but is meant for messages which have just arrived, rather than for messages
+// evaluated once more, and therefore advances the sequences by msg afterwards.
+func (e *engine) evaluateMessage(msg midi.Message, filter func(matcher.Matcher) bool) error {
+	defer e.advanceSequences(msg, time.Now())
+	return e.evaluate(msg, filter)
+}
+
+// report reports an error which occurred outside of mapMIDIMessageToKeyPress, if err is not nil.
+func (e *engine) report(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// performActions performs the actions of mp in response to msg, in order.
+// If any of them waits, they are performed by e.macros instead, and performActions returns right away.
+func (e *engine) performActions(mp *mapping.Mapping, msg midi.Message) error {
+	if mp.Waits() {
+		e.macros.start(mp, msg)
+		return nil
+	}
+	defer e.updateFeedback()
+	var at int
+	for _, a := range mp.Actions {
+		if err := e.perform(a, at, mp, msg); err != nil {
+			return err
+		}
+		at += actionSize(a)
+	}
+	return nil
+}
+
+// perform performs a, an action of mp, in response to msg. at is the index of a in the order action.Walk walks the
+// actions of mp in, which identifies it among them.
+func (e *engine) perform(a action.Action, at int, mp *mapping.Mapping, msg midi.Message) error {
+	switch a := a.(type) {
+	case action.KeyAction:
+		if a.Hold {
+			return e.holdKey(a, mp, msg)
+		}
+		if a.Toggle {
+			return e.toggleKey(a)
+		}
+		d := e.holdDuration(mp)
+		if a.For != nil {
+			ms, err := e.expressionValue(a.For, msg)
+			if err != nil {
+				return err
+			}
+			d = time.Duration(ms) * time.Millisecond
+		}
+		return e.pressKey(a.Key(), d)
+	case action.LayerAction:
+		e.changeLayers(a, msg)
+		return nil
+	case action.VariableAction:
+		e.changeVariable(a)
+		return nil
+	case action.ListAction:
+		b, bAt := e.selectAction(a, at, mp)
+		return e.perform(b, bAt, mp, msg)
+	case action.ExecAction:
+		return execute(a.Command)
+	case action.ScrollAction:
+		return e.scroll(a, msg)
+	case action.ButtonAction:
+		return e.pressButton(a, e.holdDuration(mp))
+	case action.AxisAction:
+		return e.moveAxis(a, msg)
+	case action.SendAction:
+		return e.send(a)
+	case action.RepeatAction:
+		n, err := e.expressionValue(a.Count, msg)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > action.MaxRepeat {
+			return fmt.Errorf("repeat: count %d out of range 0 to %d", n, action.MaxRepeat)
+		}
+		for i := int64(0); i < n; i++ {
+			if err := e.perform(a.Action, at+1, mp, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		panic("unreachable")
+	}
+}
+
+// changeLayers changes the active layers as described by a, which is performed in response to msg.
+func (e *engine) changeLayers(a action.LayerAction, msg midi.Message) {
+	layer := e.m.LayerIndex(a.Layer)
+	switch a.Operator {
+	case action.SwitchLayerOperator:
+		e.layers = e.layers[:1]
+		e.holds = nil
+		if layer != 0 {
+			e.layers = append(e.layers, layer)
+		}
+	case action.ToggleLayerOperator:
+		if layer == 0 {
+			// The base layer is always active.
+			return
+		}
+		if e.layerIsActive(layer) {
+			e.deactivateLayer(layer)
+		} else {
+			e.layers = append(e.layers, layer)
+		}
+	case action.HoldLayerOperator:
+		// A release cannot hold a layer, as nothing would release the layer afterwards. A layer which is already active
+		// stays active when the message is released.
+		if !isRelease(msg) && !e.layerIsActive(layer) {
+			e.layers = append(e.layers, layer)
+			e.holds = append(e.holds, layerHold{layer, msg})
+		}
+	default:
+		panic("unreachable")
+	}
+}
+
+// changeVariable changes the value of a variable as described by a.
+func (e *engine) changeVariable(a action.VariableAction) {
+	switch a.Operator {
+	case action.SetVariableOperator:
+		e.variables[a.Variable] = a.Value
+	case action.ToggleVariableOperator:
+		e.variables[a.Variable] = 1 - e.variables[a.Variable]
+	case action.IncrementVariableOperator:
+		e.variables[a.Variable] += a.Value
+	default:
+		panic("unreachable")
+	}
+}
+
+// holdDuration returns how long the keys pressed by the actions of mp are held down.
+func (e *engine) holdDuration(mp *mapping.Mapping) time.Duration {
+	if mp.Hold != 0 {
+		return mp.Hold
+	}
+	return e.m.Hold
+}
+
+// pressKey presses the key of a, holding it down for d, if d is positive.
+// Holding is done with a timer, so that pressKey returns right away, and keys may be held at the same time.
+func (e *engine) pressKey(a action.KeyAction, d time.Duration) error {
+	if d <= 0 {
+		return press(e.kb, a)
+	}
+
+	// A key which is still held is released first, so that it is pressed once more.
+	if t, ok := e.pressed[a]; ok {
+		t.Stop()
+		delete(e.pressed, a)
+		if err := release(e.kb, a); err != nil {
+			return err
+		}
+	}
+	if err := pressDown(e.kb, a); err != nil {
+		return err
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.pressed[a] == t {
+			delete(e.pressed, a)
+			e.report(release(e.kb, a))
+		}
+	})
+	e.pressed[a] = t
+	return nil
+}
+
+// holdKey holds down the key of a for mp in response to msg, unless mp already holds it.
+func (e *engine) holdKey(a action.KeyAction, mp *mapping.Mapping, msg midi.Message) error {
+	// A release cannot hold a key, as nothing would release the key afterwards, unless it is held for as long as a rate
+	// matcher matches.
+	if isRelease(msg) && !hasRateMatcher(mp.Matcher) {
+		return nil
+	}
+	for _, h := range e.keyHolds {
+		if h.mapping == mp && h.key == a {
+			return nil
+		}
+	}
+	if err := pressDown(e.kb, a); err != nil {
+		return err
+	}
+	e.keyHolds = append(e.keyHolds, keyHold{mp, a, msg})
+	return nil
+}
+
+// releaseKeys releases the held keys which are no longer to be held, as described by action.KeyAction, now that msg
+// has arrived. If msg is nil, only the keys held by mappings with rate matchers are considered.
+func (e *engine) releaseKeys(msg midi.Message) (err error) {
+	holds := e.keyHolds[:0]
+	for _, h := range e.keyHolds {
+		var released bool
+		switch {
+		case hasRateMatcher(h.mapping.Matcher):
+			released = !e.matcherMatchesMessage(h.mapping.Matcher, h.msg)
+		default:
+			released = msg != nil && releases(h.msg, msg)
+		}
+		if !released {
+			holds = append(holds, h)
+			continue
+		}
+		if rerr := release(e.kb, h.key); err == nil {
+			err = rerr
+		}
+	}
+	e.keyHolds = holds
+	return
+}
+
+// releaseAllKeys releases every held key, including those held for a hold duration and those which are latched.
+func (e *engine) releaseAllKeys() (err error) {
+	if len(e.latched) > 0 {
+		for _, a := range e.latched {
+			if rerr := release(e.kb, a); err == nil {
+				err = rerr
+			}
+		}
+		e.latched = nil
+		e.reportLatched()
+	}
+	for _, h := range e.keyHolds {
+		if rerr := release(e.kb, h.key); err == nil {
+			err = rerr
+		}
+	}
+	e.keyHolds = nil
+	for a, t := range e.pressed {
+		t.Stop()
+		if rerr := release(e.kb, a); err == nil {
+			err = rerr
+		}
+	}
+	e.pressed = make(map[action.KeyAction]*time.Timer)
+	return
+}
+
+// releaseLayers deactivates the layers held by the messages which msg releases.
+func (e *engine) releaseLayers(msg midi.Message) {
+	holds := e.holds[:0]
+	for _, h := range e.holds {
+		if releases(h.msg, msg) {
+			e.deactivateLayer(h.layer)
+		} else {
+			holds = append(holds, h)
+		}
+	}
+	e.holds = holds
+}
+
+func (e *engine) layerIsActive(layer int) bool {
+	for _, l := range e.layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *engine) deactivateLayer(layer int) {
+	for i, l := range e.layers {
+		if l == layer {
+			e.layers = append(e.layers[:i], e.layers[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopsEvaluation reports whether a matching mapping with a flow of flow stops evaluation of a map with an evaluation
+// mode of mode.
+func stopsEvaluation(mode directive.EvaluationMode, flow mapping.Flow) bool {
+	switch flow {
+	case mapping.StopFlow:
+		return true
+	case mapping.ContinueFlow:
+		return false
+	default:
+		return mode == directive.FirstEvaluation
+	}
+}
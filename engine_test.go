@@ -0,0 +1,573 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fossegrim/midimap/lang"
+	"github.com/fossegrim/midimap/lang/mapping"
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/midi/midimessage/channel"
+)
+
+// testKeyboard records the keys pressed and held down on it, rather than simulating them.
+type testKeyboard struct {
+	keys    []int
+	pressed []int
+	down    []int
+}
+
+func (kb *testKeyboard) SetKeys(keys ...int) {
+	kb.keys = keys
+}
+
+func (kb *testKeyboard) HasCTRL(bool)  {}
+func (kb *testKeyboard) HasSHIFT(bool) {}
+func (kb *testKeyboard) HasALT(bool)   {}
+func (kb *testKeyboard) HasSuper(bool) {}
+
+func (kb *testKeyboard) Launching() error {
+	kb.pressed = append(kb.pressed, kb.keys...)
+	return nil
+}
+
+func (kb *testKeyboard) Press() error {
+	kb.down = append(kb.down, kb.keys...)
+	return nil
+}
+
+func (kb *testKeyboard) Release() error {
+	down := kb.down[:0]
+	for _, k := range kb.down {
+		if !containsKey(kb.keys, k) {
+			down = append(down, k)
+		}
+	}
+	kb.down = down
+	return nil
+}
+
+func containsKey(keys []int, k int) bool {
+	for _, l := range keys {
+		if l == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (kb *testKeyboard) Clear() {
+	kb.keys = nil
+}
+
+// newTestEngine returns an engine of the map s, which must be valid, pressing keys on a testKeyboard.
+func newTestEngine(t *testing.T, s string) *engine {
+	t.Helper()
+	m, err := lang.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Parse(%q) returns an unexpected error %q.", s, err)
+	}
+	e := newEngine(&testKeyboard{}, m)
+	t.Cleanup(e.close)
+	return e
+}
+
+// receive has e receive msgs, in order.
+func receive(t *testing.T, e *engine, msgs ...midi.Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := e.mapMIDIMessageToKeyPress(msg); err != nil {
+			t.Fatalf("mapMIDIMessageToKeyPress(%v) returns an unexpected error %q.", msg, err)
+		}
+	}
+}
+
+// checkPressed checks that the keys pressed by e are wanted, in the order they were pressed.
+func checkPressed(t *testing.T, e *engine, wanted ...int) {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	pressed := e.kb.(*testKeyboard).pressed
+	if !reflect.DeepEqual(pressed, wanted) {
+		t.Errorf("The keys pressed are incorrect %v, want %v.", pressed, wanted)
+	}
+}
+
+// receiveError has e receive msg, which must result in an error of wantedErr.
+func receiveError(t *testing.T, e *engine, msg midi.Message, wantedErr string) {
+	t.Helper()
+	err := e.mapMIDIMessageToKeyPress(msg)
+	if err == nil {
+		t.Errorf("mapMIDIMessageToKeyPress(%v) returns an incorrect error %v, want %q.", msg, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("mapMIDIMessageToKeyPress(%v) returns an incorrect error %q, want %q.", msg, err, wantedErr)
+	}
+}
+
+// checkDown checks that the keys held down on the keyboard of e are wanted, in the order they were pressed.
+func checkDown(t *testing.T, e *engine, wanted ...int) {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	down := e.kb.(*testKeyboard).down
+	if len(down) != len(wanted) || len(down) != 0 && !reflect.DeepEqual(down, wanted) {
+		t.Errorf("The keys held down are incorrect %v, want %v.", down, wanted)
+	}
+}
+
+// checkVariables checks that the variables of e have the values of wanted.
+func checkVariables(t *testing.T, e *engine, wanted map[string]int64) {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, value := range wanted {
+		if e.variables[name] != value {
+			t.Errorf("Variable %q has an incorrect value %d, want %d.", name, e.variables[name], value)
+		}
+	}
+}
+
+// Test that the first evaluation mode acts upon the first matching mapping only, unless it says to continue.
+func TestEvaluateFirst(t *testing.T) {
+	e := newTestEngine(t, `evaluate first
+data1 == 36 -> 30; continue
+data1 == 36 -> 48
+data1 == 36 -> 46`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+
+	checkPressed(t, e, 30, 48)
+}
+
+// Test that the all evaluation mode acts upon every matching mapping, unless one says to stop.
+func TestEvaluateAllStop(t *testing.T) {
+	e := newTestEngine(t, `data1 == 36 -> 30
+data1 == 36 -> 48; stop
+data1 == 36 -> 46`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+
+	checkPressed(t, e, 30, 48)
+}
+
+// Test that a layer held by a note is active until the note is released, and is evaluated before the base layer.
+// The note off, which the mapping holding the layer matches too, must not hold the layer once more.
+func TestLayerHold(t *testing.T) {
+	e := newTestEngine(t, `data1 == 40 -> layer hold shifted
+data1 == 36 -> 30
+layer shifted
+data1 == 36 -> 48; stop`)
+
+	receive(t, e, channel.Channel0.NoteOn(40, 100), channel.Channel0.NoteOn(36, 100))
+	checkPressed(t, e, 48)
+
+	receive(t, e, channel.Channel0.NoteOff(40), channel.Channel0.NoteOn(36, 100))
+	checkPressed(t, e, 48, 30)
+}
+
+// Test that a layer held by a controller, such as a sustain pedal, is active until the controller is released.
+func TestLayerHoldController(t *testing.T) {
+	e := newTestEngine(t, `data1 == 64 -> layer hold shifted
+data1 == 36 -> 30
+layer shifted
+data1 == 36 -> 48; stop`)
+
+	receive(t, e, channel.Channel0.ControlChange(64, 127), channel.Channel0.NoteOn(36, 100))
+	checkPressed(t, e, 48)
+
+	receive(t, e, channel.Channel0.ControlChange(64, 0), channel.Channel0.NoteOn(36, 100))
+	checkPressed(t, e, 48, 30)
+}
+
+// Test that var actions change the variables which matchers read.
+func TestVariables(t *testing.T) {
+	e := newTestEngine(t, `var mode int
+var muted bool
+data1 == 40 -> var toggle muted
+data1 == 41 -> var inc mode
+muted == 0 && data1 == 36 -> 30
+mode == 2 && data1 == 36 -> 48`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	checkPressed(t, e, 30)
+
+	receive(t, e,
+		channel.Channel0.NoteOn(40, 100),
+		channel.Channel0.NoteOn(41, 100),
+		channel.Channel0.NoteOn(41, 100),
+		channel.Channel0.NoteOn(36, 100),
+	)
+	checkPressed(t, e, 30, 48)
+	checkVariables(t, e, map[string]int64{"mode": 2, "muted": 1})
+}
+
+// Test that a chord matches once its notes are held together, and that the notes of an exclusive chord only match on
+// their own once it is clear they do not complete it.
+func TestChords(t *testing.T) {
+	e := newTestEngine(t, `chord(36, 40) within 40ms exclusive -> 30
+chord(36, 43) -> 31
+data1 == 36 && data2 != 0 -> 48
+data1 == 40 && data2 != 0 -> 46`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(40, 100))
+	time.Sleep(60 * time.Millisecond)
+	checkPressed(t, e, 30)
+
+	receive(t, e, channel.Channel0.NoteOff(36), channel.Channel0.NoteOff(40), channel.Channel0.NoteOn(36, 100))
+	time.Sleep(60 * time.Millisecond)
+	checkPressed(t, e, 30, 48)
+
+	receive(t, e, channel.Channel0.NoteOn(43, 100))
+	checkPressed(t, e, 30, 48, 31)
+}
+
+// Test that sequences sharing a prefix, with each other and with themselves, complete independently of each other.
+func TestSequencePrefixes(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 then data1 == 36 then data1 == 38 within 600ms -> var inc a
+data1 == 36 then data1 == 38 within 600ms -> var inc b`)
+
+	receive(t, e,
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(38, 100),
+	)
+
+	checkVariables(t, e, map[string]int64{"a": 1, "b": 1})
+}
+
+// Test that messages of other kinds than those a sequence has matched, such as the control changes of a hi-hat pedal,
+// do not discard its partial matches, while notes which do not match do.
+func TestSequenceControlChanges(t *testing.T) {
+	e := newTestEngine(t, `var a int
+data1 == 36 then data1 == 36 then data1 == 38 within 600ms -> var inc a`)
+
+	receive(t, e,
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.ControlChange(4, 50),
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.ControlChange(4, 60),
+		channel.Channel0.NoteOn(38, 100),
+	)
+	checkVariables(t, e, map[string]int64{"a": 1})
+
+	receive(t, e,
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(40, 100),
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(38, 100),
+	)
+	checkVariables(t, e, map[string]int64{"a": 1})
+}
+
+// Test that mappings with different holding thresholds each match once while a note is held past both.
+func TestHoldingThresholds(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 && holding >= 30ms -> var inc a
+data1 == 36 && holding >= 60ms -> var inc b`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	time.Sleep(100 * time.Millisecond)
+
+	checkVariables(t, e, map[string]int64{"a": 1, "b": 1})
+}
+
+// Test that reloading a map stops the holding thresholds of the notes which are held from being reached afterwards.
+func TestReloadStopsHolding(t *testing.T) {
+	e := newTestEngine(t, `var a int
+data1 == 36 && holding >= 30ms -> var inc a`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	e.reload(e.m)
+	time.Sleep(60 * time.Millisecond)
+
+	checkVariables(t, e, map[string]int64{"a": 0})
+}
+
+// Test that closing an engine stops the holding thresholds of the notes which are held from being reached afterwards.
+func TestCloseStopsHolding(t *testing.T) {
+	e := newTestEngine(t, `var a int
+data1 == 36 && holding >= 30ms -> var inc a`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	e.close()
+	time.Sleep(60 * time.Millisecond)
+
+	checkVariables(t, e, map[string]int64{"a": 0})
+}
+
+// Test that held compares how long a note was held once it is released, telling short presses from long ones.
+func TestHeld(t *testing.T) {
+	e := newTestEngine(t, `data1 == 36 && held < 30ms -> 30
+data1 == 36 && held >= 30ms -> 48`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOff(36))
+	checkPressed(t, e, 30)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	time.Sleep(40 * time.Millisecond)
+	receive(t, e, channel.Channel0.NoteOff(36))
+	checkPressed(t, e, 30, 48)
+}
+
+// Test that a single tap matches taps(1) but not taps(2), and that a double tap matches taps(2) but not taps(1).
+func TestTaps(t *testing.T) {
+	e := newTestEngine(t, `var one int
+var two int
+data1 == 36 && taps(1) within 50ms -> var inc one
+data1 == 36 && taps(2) within 50ms -> var inc two`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	time.Sleep(100 * time.Millisecond)
+	checkVariables(t, e, map[string]int64{"one": 1, "two": 0})
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(36, 100))
+	time.Sleep(100 * time.Millisecond)
+	checkVariables(t, e, map[string]int64{"one": 1, "two": 1})
+}
+
+// Test that a map of a single mapping without an action, as evaluated by the log command, reports the messages matched
+// after a delay.
+func TestMatchedTaps(t *testing.T) {
+	m, err := matcher.Parse("taps(2) within 50ms")
+	if err != nil {
+		t.Fatalf("Parse returns an unexpected error %q.", err)
+	}
+	e := newEngine(&testKeyboard{}, lang.Map{
+		Layers: []lang.Layer{{Name: lang.BaseLayer, Mappings: []mapping.Mapping{{Matcher: m}}}},
+	})
+	var matched int
+	e.matched = func(msg midi.Message) {
+		matched++
+	}
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(36, 100))
+	time.Sleep(100 * time.Millisecond)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if matched != 1 {
+		t.Errorf("The mapping matches %d messages, want 1.", matched)
+	}
+}
+
+// Test that membership operators match the messages whose operand is, or is not, in a set of ranges.
+func TestMembership(t *testing.T) {
+	e := newTestEngine(t, `data1 in {36, 40..42} && data2 not in 1..20 -> 30`)
+
+	receive(t, e,
+		channel.Channel0.NoteOn(36, 100),
+		channel.Channel0.NoteOn(38, 100),
+		channel.Channel0.NoteOn(41, 100),
+		channel.Channel0.NoteOn(41, 10),
+		channel.Channel0.NoteOn(43, 100),
+	)
+
+	checkPressed(t, e, 30, 30)
+}
+
+// Test that pad matches only the notes of pads and control only control changes, named by the device profile of the map.
+func TestPadsAndControls(t *testing.T) {
+	e := newTestEngine(t, `device td1
+pad == snare && data2 != 0 -> 30
+control == hihat -> 48`)
+
+	receive(t, e, channel.Channel0.NoteOn(38, 100), channel.Channel0.ControlChange(38, 100))
+	checkPressed(t, e, 30)
+
+	receive(t, e, channel.Channel0.ControlChange(4, 100), channel.Channel0.NoteOn(4, 100))
+	checkPressed(t, e, 30, 48)
+}
+
+// Test that direction and delta compare a control change with the previous change of the same controller, and do not
+// match its first change.
+func TestControllerChanges(t *testing.T) {
+	e := newTestEngine(t, `data1 == 4 && direction == 1 -> 30
+data1 == 4 && delta <= -20 -> 48`)
+
+	receive(t, e, channel.Channel0.ControlChange(4, 50), channel.Channel0.ControlChange(5, 10))
+	checkPressed(t, e)
+
+	receive(t, e, channel.Channel0.ControlChange(4, 60), channel.Channel0.ControlChange(4, 50))
+	checkPressed(t, e, 30)
+
+	receive(t, e, channel.Channel0.ControlChange(4, 20))
+	checkPressed(t, e, 30, 48)
+}
+
+// Test that a crossing matcher matches once as a controller crosses its threshold, and not again before the controller
+// has crossed back to its rearm level.
+func TestCrossing(t *testing.T) {
+	e := newTestEngine(t, `var a int
+data1 == 4 && crosses up 100 rearm 90 -> var inc a`)
+
+	receive(t, e, channel.Channel0.ControlChange(4, 95), channel.Channel0.ControlChange(4, 100),
+		channel.Channel0.ControlChange(4, 105))
+	checkVariables(t, e, map[string]int64{"a": 1})
+
+	receive(t, e, channel.Channel0.ControlChange(4, 95), channel.Channel0.ControlChange(4, 110))
+	checkVariables(t, e, map[string]int64{"a": 1})
+
+	receive(t, e, channel.Channel0.ControlChange(4, 80), channel.Channel0.ControlChange(4, 101))
+	checkVariables(t, e, map[string]int64{"a": 2})
+}
+
+// Test that rate matchers whose matchers differ count different messages, even if they compare operands of different
+// kinds to the same value.
+func TestRates(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 38 && rate(data1 == 38) >= 2/s -> var inc a
+data1 == 40 && rate(prev(data2) == 38) >= 2/s -> var inc b`)
+
+	receive(t, e, channel.Channel0.NoteOn(38, 100), channel.Channel0.NoteOn(38, 100), channel.Channel0.NoteOn(40, 100))
+
+	checkVariables(t, e, map[string]int64{"a": 1, "b": 0})
+}
+
+// Test that a held key is released along with the message which held it.
+func TestHoldKey(t *testing.T) {
+	e := newTestEngine(t, `data1 == 36 -> hold 30`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	checkDown(t, e, 30)
+
+	receive(t, e, channel.Channel0.NoteOff(36))
+	checkDown(t, e)
+}
+
+// Test that a release, which the mapping holding a key matches too, does not hold the key, as nothing would release it.
+func TestHoldKeyRelease(t *testing.T) {
+	e := newTestEngine(t, `data1 == 64 -> hold 30`)
+
+	receive(t, e, channel.Channel0.ControlChange(64, 127), channel.Channel0.ControlChange(64, 0))
+	checkDown(t, e)
+
+	receive(t, e, channel.Channel0.ControlChange(64, 0), channel.Channel0.NoteOff(64))
+	checkDown(t, e)
+	if len(e.keyHolds) != 0 {
+		t.Errorf("The keys held are incorrect %v, want [].", e.keyHolds)
+	}
+}
+
+// Test that identical list actions of a mapping select their actions independently of each other.
+func TestIdenticalLists(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 && data2 != 0 -> cycle(cycle(var inc a, var inc b), cycle(var inc a, var inc b))`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(36, 100))
+
+	checkVariables(t, e, map[string]int64{"a": 2, "b": 0})
+}
+
+// Test that identical list actions among the actions of a mapping select their actions independently of each other.
+func TestIdenticalListActions(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 && data2 != 0 -> cycle(var inc a, var inc b), cycle(var inc a, var inc b)`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+
+	checkVariables(t, e, map[string]int64{"a": 2, "b": 0})
+}
+
+// Test that the macros of different mappings are performed at the same time, and those of a mapping one after the
+// other.
+func TestMacros(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 && data2 != 0 -> wait 300ms, var inc a
+data1 == 38 && data2 != 0 -> wait 40ms, var inc b`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(38, 100), channel.Channel0.NoteOn(38, 100))
+	time.Sleep(60 * time.Millisecond)
+	checkVariables(t, e, map[string]int64{"a": 0, "b": 1})
+
+	time.Sleep(60 * time.Millisecond)
+	checkVariables(t, e, map[string]int64{"a": 0, "b": 2})
+}
+
+// Test that a key is held down for the hold duration of its mapping, or else of the map, rather than being pressed.
+func TestHoldDuration(t *testing.T) {
+	e := newTestEngine(t, `hold 60ms
+data1 == 36 && data2 != 0 -> 30
+data1 == 38 && data2 != 0 -> 48; hold 20ms`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOn(38, 100))
+	checkDown(t, e, 30, 48)
+
+	time.Sleep(40 * time.Millisecond)
+	checkDown(t, e, 30)
+
+	time.Sleep(40 * time.Millisecond)
+	checkDown(t, e)
+	checkPressed(t, e)
+}
+
+// Test that a repeat action performs its action as many times as its count, and that counts which are negative or
+// greater than action.MaxRepeat are errors.
+func TestRepeat(t *testing.T) {
+	e := newTestEngine(t, `var a int
+data1 == 36 -> repeat(data2 / 32, var inc a)
+data1 == 38 -> repeat(data2 * data2, var inc a)
+data1 == 40 -> repeat(data2 - 64, var inc a)`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100))
+	checkVariables(t, e, map[string]int64{"a": 3})
+
+	receiveError(t, e, channel.Channel0.NoteOn(38, 100), "repeat: count 10000 out of range 0 to 128")
+	receiveError(t, e, channel.Channel0.NoteOn(40, 10), "repeat: count -54 out of range 0 to 128")
+	checkVariables(t, e, map[string]int64{"a": 3})
+}
+
+// Test that a toggle key action latches its key until it is performed once more, and that closing an engine releases
+// the latched keys.
+func TestToggleKey(t *testing.T) {
+	e := newTestEngine(t, `data1 == 36 && data2 != 0 -> toggle f
+data1 == 38 && data2 != 0 -> toggle j`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 100), channel.Channel0.NoteOff(36))
+	checkDown(t, e, 33)
+
+	receive(t, e, channel.Channel0.NoteOn(38, 100), channel.Channel0.NoteOn(36, 100))
+	checkDown(t, e, 36)
+
+	e.close()
+	checkDown(t, e)
+}
+
+// Test that steps decodes how many steps an encoder was turned by, and has no value for other controllers.
+func TestEncoderSteps(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+encoder 16 offset
+steps > 0 -> repeat(steps, var inc a)
+steps < 0 -> repeat(-steps, var inc b)`)
+
+	receive(t, e, channel.Channel0.ControlChange(16, 67), channel.Channel0.ControlChange(16, 63))
+	checkVariables(t, e, map[string]int64{"a": 3, "b": 1})
+
+	receive(t, e, channel.Channel0.ControlChange(17, 67), channel.Channel0.NoteOn(16, 67))
+	checkVariables(t, e, map[string]int64{"a": 3, "b": 1})
+}
+
+// Test that greater than does not match values equal to its right operand, unlike greater than or equal to.
+func TestGreaterThan(t *testing.T) {
+	e := newTestEngine(t, `var a int
+var b int
+data1 == 36 && data2 > 80 -> var inc a
+data1 == 36 && data2 >= 80 -> var inc b`)
+
+	receive(t, e, channel.Channel0.NoteOn(36, 80))
+	checkVariables(t, e, map[string]int64{"a": 0, "b": 1})
+
+	receive(t, e, channel.Channel0.NoteOn(36, 81))
+	checkVariables(t, e, map[string]int64{"a": 1, "b": 2})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+)
+
+// eviocGrab is the ioctl of linux/input.h which grabs an input device, so that its events are only read by the grabber.
+const eviocGrab = 0x40044590
+
+// readKeys reads the events of the input device named name, calling f for every key which is pressed or released,
+// until reading fails. If grab, the device is grabbed, so that other programs do not see its keys.
+func readKeys(name string, grab bool, f func(keycode int, pressed bool)) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if grab {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), eviocGrab, 1); errno != 0 {
+			return errno
+		}
+	}
+
+	for {
+		var ev inputEvent
+		if err := binary.Read(file, binary.LittleEndian, &ev); err != nil {
+			return err
+		}
+		// A value of 2 is a key being repeated while it is held, which is ignored.
+		if ev.Type == evKey && ev.Value != 2 {
+			f(int(ev.Code), ev.Value == 1)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+// readKeys reads the keys of an input device, which is only supported on Linux.
+func readKeys(name string, grab bool, f func(keycode int, pressed bool)) error {
+	return errors.New("reading input devices is only supported on Linux")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/directive"
+	"gitlab.com/gomidi/midi"
+)
+
+// setOutput makes out the output port of e, which send actions and the feedback of the map send messages to, and sends
+// the feedback of the current state.
+func (e *engine) setOutput(out midi.Out) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.out = out
+	e.updateFeedback()
+}
+
+// send sends the message of a, a send action.
+func (e *engine) send(a action.SendAction) error {
+	if e.out == nil {
+		return errors.New("send: no output port, as given by -out")
+	}
+	raw := a.Message.On()
+	if a.Off {
+		raw = a.Message.Off()
+	}
+	_, err := e.out.Write(raw)
+	return err
+}
+
+// updateFeedback sends the feedback of the map whose state has changed since it was last sent.
+func (e *engine) updateFeedback() {
+	if e.out == nil {
+		return
+	}
+	// The feedback has not been sent since the map was loaded, so all of it is.
+	initial := len(e.feedback) != len(e.m.Feedback)
+	if initial {
+		e.feedback = make([]bool, len(e.m.Feedback))
+	}
+	for i, f := range e.m.Feedback {
+		on := e.feedbackState(f)
+		if !initial && on == e.feedback[i] {
+			continue
+		}
+		e.feedback[i] = on
+		raw := f.Message.Off()
+		if on {
+			raw = f.Message.On()
+		}
+		_, err := e.out.Write(raw)
+		e.report(err)
+	}
+}
+
+// clearFeedback turns off the feedback of the map which is on.
+func (e *engine) clearFeedback() {
+	if e.out != nil {
+		for i, on := range e.feedback {
+			if on {
+				_, err := e.out.Write(e.m.Feedback[i].Message.Off())
+				e.report(err)
+			}
+		}
+	}
+	e.feedback = nil
+}
+
+// feedbackState reports whether the state which f mirrors is on.
+func (e *engine) feedbackState(f directive.FeedbackDirective) bool {
+	switch f.Kind {
+	case directive.LayerFeedback:
+		return e.layerIsActive(e.m.LayerIndex(f.Name))
+	case directive.VariableFeedback:
+		return e.variables[f.Name] != 0
+	case directive.ToggleFeedback:
+		for _, key := range e.latched {
+			if key == f.Key {
+				return true
+			}
+		}
+		return false
+	default:
+		panic("unreachable")
+	}
+}
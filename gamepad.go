@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fossegrim/midimap/lang"
+	"github.com/fossegrim/midimap/lang/action"
+	"gitlab.com/gomidi/midi"
+)
+
+// openGamepad creates the virtual gamepad of e if an action of m presses its buttons or moves its axes, unless it has
+// been created already.
+// It must not be called with e.mu held.
+func (e *engine) openGamepad(m lang.Map) error {
+	e.mu.Lock()
+	open := e.gamepad != nil
+	e.mu.Unlock()
+	if open || !usesAction(m, isGamepadAction) {
+		return nil
+	}
+	codes := map[uint16][]uint16{evKey: nil, evAbs: nil}
+	absMin := make(map[uint16]int32)
+	absMax := make(map[uint16]int32)
+	for i := 0; i < action.MaxButton; i++ {
+		codes[evKey] = append(codes[evKey], btnSouth+uint16(i))
+	}
+	for axis := action.XAxis; axis <= action.RudderAxis; axis++ {
+		code := absX + uint16(axis)
+		codes[evAbs] = append(codes[evAbs], code)
+		absMin[code] = 0
+		absMax[code] = 127
+	}
+	g, err := newUinputDevice("midimap gamepad", codes, absMin, absMax)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gamepad != nil {
+		// Another map was loaded meanwhile, creating the gamepad too.
+		return g.close()
+	}
+	e.gamepad = g
+	e.buttons = make(map[int]*time.Timer)
+	return nil
+}
+
+func isGamepadAction(a action.Action) bool {
+	switch a.(type) {
+	case action.ButtonAction, action.AxisAction:
+		return true
+	default:
+		return false
+	}
+}
+
+// errNoGamepad is returned by the gamepad actions if creating the gamepad failed, which has been reported already.
+var errNoGamepad = errors.New("gamepad: no virtual gamepad")
+
+// pressButton presses the button of a, holding it down for d, if d is positive.
+// Holding is done with a timer, just like pressKey does.
+func (e *engine) pressButton(a action.ButtonAction, d time.Duration) error {
+	if e.gamepad == nil {
+		return errNoGamepad
+	}
+	code := btnSouth + uint16(a.Button-1)
+	if t, ok := e.buttons[a.Button]; ok {
+		t.Stop()
+		delete(e.buttons, a.Button)
+		if err := e.gamepad.send(evKey, code, 0); err != nil {
+			return err
+		}
+	}
+	if err := e.gamepad.send(evKey, code, 1); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return e.gamepad.send(evKey, code, 0)
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.gamepad != nil && e.buttons[a.Button] == t {
+			delete(e.buttons, a.Button)
+			e.report(e.gamepad.send(evKey, code, 0))
+		}
+	})
+	e.buttons[a.Button] = t
+	return nil
+}
+
+// moveAxis moves the axis of a as described by a, which is performed in response to msg.
+func (e *engine) moveAxis(a action.AxisAction, msg midi.Message) error {
+	n, err := e.expressionValue(a.Value, msg)
+	if err != nil {
+		return err
+	}
+	if e.gamepad == nil {
+		return errNoGamepad
+	}
+	switch {
+	case n < 0:
+		n = 0
+	case n > 127:
+		n = 127
+	}
+	return e.gamepad.send(evAbs, absX+uint16(a.Axis), int32(n))
+}
@@ -3,58 +3,47 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os"
+	"os/exec"
 	"strconv"
 
-	"github.com/fossegrim/midimap/lang/matcher"
 	"gitlab.com/gomidi/midi"
 )
 
-// matcherMatchesMessage reports whether m matches message.
-func matcherMatchesMessage(m matcher.Matcher, msg midi.Message) bool {
-	switch m := m.(type) {
-	case matcher.MatcherWithoutLogicalOperator:
-		var data int64
-		// msg.Raw()[0] is status
-		// msg.Raw()[1] is data1
-		// msg.Raw()[2] is data2
-		switch m.LeftOperand {
-		case matcher.Data1:
-			data = int64(msg.Raw()[1])
-		case matcher.Data2:
-			data = int64(msg.Raw()[2])
-		default:
-			panic("unreachable")
-		}
+// releases reports whether msg releases what press pressed, that is whether msg is a note off of the note of a note
+// on press, or a control change with a value below 64 of the controller of a control change press.
+// The note offs include note ons with a velocity of 0, which many devices send instead.
+func releases(press, msg midi.Message) bool {
+	p, m := press.Raw(), msg.Raw()
+	if len(p) < 3 || len(m) < 3 || p[0]&0x0f != m[0]&0x0f || p[1] != m[1] {
+		return false
+	}
+	switch p[0] & 0xf0 {
+	case 0x90: // note on
+		return m[0]&0xf0 == 0x80 || m[0]&0xf0 == 0x90 && m[2] == 0
+	case 0xb0: // control change
+		return m[0]&0xf0 == 0xb0 && m[2] < 64
+	default:
+		return false
+	}
+}
 
-		switch m.Operator {
-		case matcher.LessThanOperator:
-			return data < m.RightOperand
-		case matcher.LessThanOrEqualToOperator:
-			return data <= m.RightOperand
-		case matcher.EqualToOperator:
-			return data == m.RightOperand
-		case matcher.UnequalToOperator:
-			return data != m.RightOperand
-		case matcher.GreaterThanOrEqualToOperator:
-			return data >= m.RightOperand
-		case matcher.GreaterThanOperator:
-			return data >= m.RightOperand
-		default:
-			panic("unreachable")
-		}
-	case matcher.MatcherWithLogicalOperator:
-		switch m.Operator {
-		case matcher.LogicalAndOperator:
-			return matcherMatchesMessage(m.LeftMatcher, msg) &&
-				matcherMatchesMessage(m.RightMatcher, msg)
-		case matcher.LogicalOrOperator:
-			return matcherMatchesMessage(m.LeftMatcher, msg) ||
-				matcherMatchesMessage(m.RightMatcher, msg)
-		default:
-			panic("unreachable")
-		}
+// isRelease reports whether msg releases what a message may have pressed, as described by releases, that is whether
+// msg is a note off, including a note on with a velocity of 0, or a control change with a value below 64.
+func isRelease(msg midi.Message) bool {
+	m := msg.Raw()
+	if len(m) < 3 {
+		return false
+	}
+	switch m[0] & 0xf0 {
+	case 0x80: // note off
+		return true
+	case 0x90: // note on
+		return m[2] == 0
+	case 0xb0: // control change
+		return m[2] < 64
 	default:
-		panic("unreachable")
+		return false
 	}
 }
 
@@ -72,6 +61,19 @@ func getInByPortNumber(ins []midi.In, number uint64) (in midi.In, err error) {
 	return
 }
 
+// getOutByPortNumber is like getInByPortNumber, but retrieves the midi.Out by
+// number portNumber from outs.
+func getOutByPortNumber(outs []midi.Out, number uint64) (out midi.Out, err error) {
+	for _, innerOut := range outs {
+		if uint64(innerOut.Number()) == number {
+			out = innerOut
+			return
+		}
+	}
+	err = fmt.Errorf("no MIDI output port by number %d", number)
+	return
+}
+
 // parsePortNumber parses a port number.
 func parsePortNumber(s string) (portNumber uint64, err error) {
 	portNumber, err = strconv.ParseUint(s, 10, 0)
@@ -80,3 +82,16 @@ func parsePortNumber(s string) (portNumber uint64, err error) {
 	}
 	return
 }
+
+// execute runs command with sh(1), without waiting for it to exit.
+func execute(command string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Wait for the command in the background, so that it does not linger as a zombie once it exits.
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// durationValue returns the value of o for msg, and whether o has a value for msg at all.
+func (e *engine) durationValue(o matcher.Duration, msg midi.Message) (time.Duration, bool) {
+	n, on, ok := noteOf(msg)
+	if !ok {
+		return 0, false
+	}
+	switch o {
+	case matcher.Held:
+		d, ok := e.heldFor[n]
+		return d, ok && !on
+	case matcher.Holding:
+		return e.holding, on && e.holding != 0
+	default:
+		panic("unreachable")
+	}
+}
+
+// holdingThreshold returns the duration after which m, which compares how long a note has been held to a duration,
+// first matches a note which is still held, if m is such a matcher at all.
+func holdingThreshold(m matcher.Matcher) (time.Duration, bool) {
+	c, ok := m.(matcher.MatcherWithoutLogicalOperator)
+	if !ok || c.LeftOperand != matcher.Holding {
+		return 0, false
+	}
+	d := time.Duration(c.RightOperand) * time.Millisecond
+	switch c.Operator {
+	case matcher.EqualToOperator, matcher.GreaterThanOrEqualToOperator:
+	case matcher.GreaterThanOperator:
+		d += time.Millisecond
+	default:
+		// The other comparisons hold right away, when there is no need to wait.
+		return 0, false
+	}
+	return d, d > 0
+}
+
+func (e *engine) addHoldingThreshold(d time.Duration) {
+	for _, t := range e.holdingThresholds {
+		if t == d {
+			return
+		}
+	}
+	e.holdingThresholds = append(e.holdingThresholds, d)
+}
+
+// scheduleHolding schedules the evaluation of msg, if it is a note on, for each holding threshold that passes while the
+// note is still held.
+// At those evaluations, only the mappings with a holding operand whose threshold it is are evaluated, with holding
+// operands having the value of the threshold, so that each of them matches once per press.
+func (e *engine) scheduleHolding(msg midi.Message) {
+	n, on, ok := noteOf(msg)
+	if !ok {
+		return
+	}
+	// The note was released, or is pressed once more, so the evaluations of its previous press are no longer due.
+	e.stopHolding(n)
+	if !on {
+		return
+	}
+	for _, d := range e.holdingThresholds {
+		d := d
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if !containsTimer(e.holdingTimers[n], t) {
+				// The timer was stopped after it fired.
+				return
+			}
+			e.holding = d
+			e.report(e.evaluate(msg, hasHoldingThreshold(d)))
+			e.holding = 0
+		})
+		e.holdingTimers[n] = append(e.holdingTimers[n], t)
+	}
+}
+
+// stopHolding stops the timers scheduled by scheduleHolding for n.
+func (e *engine) stopHolding(n note) {
+	for _, t := range e.holdingTimers[n] {
+		t.Stop()
+	}
+	delete(e.holdingTimers, n)
+}
+
+// stopAllHolding stops every timer scheduled by scheduleHolding.
+func (e *engine) stopAllHolding() {
+	for n := range e.holdingTimers {
+		e.stopHolding(n)
+	}
+	e.holdingTimers = make(map[note][]*time.Timer)
+}
+
+// containsTimer reports whether timers contains t.
+func containsTimer(timers []*time.Timer, t *time.Timer) bool {
+	for _, u := range timers {
+		if u == t {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHoldingThreshold returns a function reporting whether a matcher is or is composed of a matcher with a holding
+// operand, whose holding threshold is d.
+func hasHoldingThreshold(d time.Duration) func(matcher.Matcher) bool {
+	return func(m matcher.Matcher) (has bool) {
+		matcher.Walk(m, func(n matcher.Matcher) {
+			if t, ok := holdingThreshold(n); ok && t == d {
+				has = true
+			}
+		})
+		return
+	}
+}
@@ -0,0 +1,670 @@
+// The action package parses actions, that is what a mapping does when its matcher matches a message.
+package action
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/helper"
+	"github.com/fossegrim/midimap/lang/keycode"
+	"github.com/fossegrim/midimap/lang/matcher"
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Parse parses an action as specified in Section 1.2.2 ACTIONS of the midimap-lang specification.
+//
+// If s is a valid action as described by the specification, Parse returns action, nil.
+// Otherwise, Parse returns an error describing why the action is invalid.
+// s may not contain any leading or trailing space characters.
+func Parse(s string) (Action, error) {
+	return ParseInScope(s, matcher.DefaultScope)
+}
+
+// ParseInScope is like Parse, but parses the expressions of s in scope rather than in the default scope.
+func ParseInScope(s string, scope matcher.Scope) (Action, error) {
+	fields := strings.Fields(s)
+	if len(fields) > 0 {
+		switch fields[0] {
+		case "layer":
+			return parseLayerAction(s, fields[1:])
+		case "var":
+			return parseVariableAction(s, fields[1:])
+		case "hold":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("action %q: hold takes exactly one argument", s)
+			}
+			a, err := parseKeyAction(s, fields[1])
+			a.Hold = true
+			return a, err
+		case "toggle":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("action %q: toggle takes exactly one argument", s)
+			}
+			a, err := parseKeyAction(s, fields[1])
+			a.Toggle = true
+			return a, err
+		case "wait":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("action %q: wait takes exactly one argument", s)
+			}
+			d, err := matcher.ParseDuration(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("action %q: no valid duration", s)
+			}
+			return WaitAction{d}, nil
+		case "exec":
+			return parseExecAction(s)
+		case "scroll", "hscroll":
+			return parseScrollAction(s, fields[0], scope)
+		case "button":
+			return parseButtonAction(s, fields[1:])
+		case "axis":
+			return parseAxisAction(s, scope)
+		case "send":
+			return parseSendAction(s, fields[1:])
+		}
+	}
+
+	for prefix, policy := range listPolicies {
+		if strings.HasPrefix(s, prefix+"(") {
+			return parseListAction(s, prefix, policy, scope)
+		}
+	}
+	if strings.HasPrefix(s, "repeat(") {
+		return parseRepeatAction(s, scope)
+	}
+
+	// A key may be pressed for a duration given by an expression, such as f for data2 * 2ms.
+	if key, duration, ok := helper.BeforeAndAfterOutsideBrackets(forRegexp, s); ok {
+		a, err := parseKeyAction(s, strings.TrimSpace(key))
+		if err != nil {
+			return nil, err
+		}
+		a.For, err = scope.ParseExpression(duration)
+		if err != nil {
+			return nil, fmt.Errorf("action %q: %v", s, err)
+		}
+		return a, nil
+	}
+
+	return parseKeyAction(s, s)
+}
+
+// ParseList parses a comma separated list of actions, such as f, wait 30ms, exec "notify-send don".
+//
+// If s is a valid list of actions, ParseList returns actions, nil.
+// Otherwise, ParseList returns an error describing why the first invalid action is invalid.
+// s may not contain any leading or trailing space characters.
+func ParseList(s string) ([]Action, error) {
+	return ParseListInScope(s, matcher.DefaultScope)
+}
+
+// ParseListInScope is like ParseList, but parses the expressions of s in scope rather than in the default scope.
+func ParseListInScope(s string, scope matcher.Scope) (actions []Action, err error) {
+	for _, element := range splitList(s) {
+		var a Action
+		a, err = ParseInScope(element, scope)
+		if err != nil {
+			return
+		}
+		actions = append(actions, a)
+	}
+	return
+}
+
+// parseExecAction parses an exec action.
+func parseExecAction(s string) (a ExecAction, err error) {
+	command := strings.TrimSpace(strings.TrimPrefix(s, "exec"))
+	a.Command, err = strconv.Unquote(command)
+	if err != nil || !strings.HasPrefix(command, `"`) || a.Command == "" {
+		err = fmt.Errorf("action %q: exec takes exactly one argument, a quoted command", s)
+	}
+	return
+}
+
+// parseScrollAction parses a scroll action, whose name is name.
+func parseScrollAction(s string, name string, scope matcher.Scope) (a ScrollAction, err error) {
+	a.Horizontal = name == "hscroll"
+	a.Amount, err = scope.ParseExpression(strings.TrimPrefix(s, name))
+	if err != nil {
+		err = fmt.Errorf("action %q: %v", s, err)
+	}
+	return
+}
+
+// parseButtonAction parses the arguments of a button action.
+func parseButtonAction(s string, args []string) (a ButtonAction, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("action %q: button takes exactly one argument", s)
+		return
+	}
+	n, err := matcher.ParseInteger(args[0])
+	if err != nil || n < 1 || n > MaxButton {
+		err = fmt.Errorf("action %q: no valid button, which is 1 to %d", s, MaxButton)
+		return
+	}
+	a.Button = int(n)
+	return
+}
+
+// axes are the axes of the gamepad by name.
+var axes = map[string]Axis{
+	"x":        XAxis,
+	"y":        YAxis,
+	"z":        ZAxis,
+	"rx":       RXAxis,
+	"ry":       RYAxis,
+	"rz":       RZAxis,
+	"throttle": ThrottleAxis,
+	"rudder":   RudderAxis,
+}
+
+// parseAxisAction parses an axis action.
+func parseAxisAction(s string, scope matcher.Scope) (a AxisAction, err error) {
+	assignment := strings.TrimSpace(strings.TrimPrefix(s, "axis"))
+	i := strings.Index(assignment, "=")
+	if i == -1 {
+		err = fmt.Errorf("action %q: axis takes an axis, = and a value", s)
+		return
+	}
+	var ok bool
+	a.Axis, ok = axes[strings.TrimSpace(assignment[:i])]
+	if !ok {
+		err = fmt.Errorf("action %q: no valid axis", s)
+		return
+	}
+	a.Value, err = scope.ParseExpression(assignment[i+1:])
+	if err != nil {
+		err = fmt.Errorf("action %q: %v", s, err)
+	}
+	return
+}
+
+// parseSendAction parses the arguments of a send action.
+func parseSendAction(s string, args []string) (a SendAction, err error) {
+	if len(args) > 0 && args[0] == "off" {
+		a.Off = true
+		args = args[1:]
+	}
+	a.Message, err = message.Parse(strings.Join(args, " "))
+	if err != nil {
+		err = fmt.Errorf("action %q: %v", s, err)
+	}
+	return
+}
+
+// splitList splits s into the elements of a comma separated list, which are trimmed of spaces.
+// The commas enclosed in parentheses are not separators.
+func splitList(s string) (elements []string) {
+	for {
+		element, rest, ok := helper.BeforeAndAfterOutsideBrackets(commaRegexp, s)
+		if !ok {
+			return append(elements, strings.TrimSpace(s))
+		}
+		elements = append(elements, strings.TrimSpace(element))
+		s = rest
+	}
+}
+
+// modifiers are the modifiers by name.
+var modifiers = map[string]Modifiers{
+	"ctrl":  CtrlModifier,
+	"shift": ShiftModifier,
+	"alt":   AltModifier,
+	"super": SuperModifier,
+}
+
+// parseKeyAction parses key, the key of the key action s, which is a keycode optionally preceded by modifiers, such as
+// ctrl+shift+s.
+func parseKeyAction(s, key string) (a KeyAction, err error) {
+	keys := strings.Split(key, "+")
+	for _, name := range keys[:len(keys)-1] {
+		m, ok := modifiers[name]
+		if !ok || a.Modifiers&m != 0 {
+			err = fmt.Errorf("action %q: no valid modifier %q", s, name)
+			return
+		}
+		a.Modifiers |= m
+	}
+	a.Keycode, err = keycode.Parse(keys[len(keys)-1])
+	return
+}
+
+// listPolicies are the selection policies of list actions by name.
+var listPolicies = map[string]ListPolicy{
+	"cycle":     CyclePolicy,
+	"alternate": AlternatePolicy,
+	"random":    RandomPolicy,
+}
+
+// parseListAction parses a list action, such as cycle(f, j), whose policy is named name.
+func parseListAction(s string, name string, policy ListPolicy, scope matcher.Scope) (a ListAction, err error) {
+	a.Policy = policy
+	if !strings.HasSuffix(s, ")") {
+		err = fmt.Errorf("action %q: unterminated %s", s, name)
+		return
+	}
+	a.Actions, err = ParseListInScope(s[len(name)+1:len(s)-1], scope)
+	if err != nil {
+		return
+	}
+	for _, b := range a.Actions {
+		if _, ok := b.(WaitAction); ok {
+			err = fmt.Errorf("action %q: %s cannot wait", s, name)
+			return
+		}
+	}
+	if len(a.Actions) < 2 {
+		err = fmt.Errorf("action %q: %s takes at least two actions", s, name)
+	}
+	return
+}
+
+// parseRepeatAction parses a repeat action, such as repeat(data2 / 32, f).
+func parseRepeatAction(s string, scope matcher.Scope) (a RepeatAction, err error) {
+	if !strings.HasSuffix(s, ")") {
+		err = fmt.Errorf("action %q: unterminated repeat", s)
+		return
+	}
+	count, rest, ok := helper.BeforeAndAfterOutsideBrackets(commaRegexp, s[len("repeat("):len(s)-1])
+	if !ok {
+		err = fmt.Errorf("action %q: repeat takes a count and an action", s)
+		return
+	}
+	a.Count, err = scope.ParseExpression(count)
+	if err != nil {
+		err = fmt.Errorf("action %q: %v", s, err)
+		return
+	}
+	if c, ok := a.Count.(matcher.Constant); ok && (c.Value < 0 || c.Value > MaxRepeat) {
+		err = fmt.Errorf("action %q: count out of range 0 to %d", s, MaxRepeat)
+		return
+	}
+	a.Action, err = ParseInScope(strings.TrimSpace(rest), scope)
+	if err != nil {
+		return
+	}
+	var waits bool
+	Walk(a.Action, func(b Action) {
+		_, ok := b.(WaitAction)
+		waits = waits || ok
+	})
+	if waits {
+		err = fmt.Errorf("action %q: repeat cannot wait", s)
+	}
+	return
+}
+
+var (
+	commaRegexp = regexp.MustCompile(",")
+	forRegexp   = regexp.MustCompile(" for ")
+)
+
+// parseLayerAction parses the arguments of a layer action.
+func parseLayerAction(s string, args []string) (a LayerAction, err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("action %q: layer takes exactly two arguments", s)
+		return
+	}
+	switch args[0] {
+	case "switch":
+		a.Operator = SwitchLayerOperator
+	case "toggle":
+		a.Operator = ToggleLayerOperator
+	case "hold":
+		a.Operator = HoldLayerOperator
+	default:
+		err = fmt.Errorf("action %q: no valid layer operator", s)
+		return
+	}
+	a.Layer = args[1]
+	return
+}
+
+// parseVariableAction parses the arguments of a var action.
+func parseVariableAction(s string, args []string) (a VariableAction, err error) {
+	if len(args) < 2 {
+		err = fmt.Errorf("action %q: var takes an operator and a variable", s)
+		return
+	}
+	a.Variable = args[1]
+	switch args[0] {
+	case "set":
+		if len(args) != 4 || args[2] != "=" {
+			err = fmt.Errorf("action %q: var set takes a variable, = and a value", s)
+			return
+		}
+		a.Operator = SetVariableOperator
+		a.Value, err = matcher.ParseInteger(args[3])
+		if err != nil {
+			err = fmt.Errorf("action %q: no valid value", s)
+		}
+	case "toggle":
+		if len(args) != 2 {
+			err = fmt.Errorf("action %q: var toggle takes a variable", s)
+			return
+		}
+		a.Operator = ToggleVariableOperator
+	case "inc", "dec":
+		if len(args) > 3 {
+			err = fmt.Errorf("action %q: var %s takes a variable and optionally an amount", s, args[0])
+			return
+		}
+		a.Operator = IncrementVariableOperator
+		a.Value = 1
+		if len(args) == 3 {
+			a.Value, err = matcher.ParseInteger(args[2])
+			if err != nil {
+				err = fmt.Errorf("action %q: no valid amount", s)
+				return
+			}
+		}
+		if args[0] == "dec" {
+			a.Value = -a.Value
+		}
+	default:
+		err = fmt.Errorf("action %q: no valid variable operator", s)
+	}
+	return
+}
+
+// Action is a discriminated union of all the actions.
+//
+// See the Matcher type of the matcher package for an explanation of how discriminated unions are represented.
+type Action interface {
+	isAction()
+	Equal(Action) bool
+}
+
+// Walk calls f for a and for every action which a is composed of, in depth-first order.
+func Walk(a Action, f func(Action)) {
+	f(a)
+	switch a := a.(type) {
+	case ListAction:
+		for _, b := range a.Actions {
+			Walk(b, f)
+		}
+	case RepeatAction:
+		Walk(a.Action, f)
+	}
+}
+
+// KeyAction represents pressing a key along with its Modifiers, such as:
+// 33
+// ctrl+x
+// hold 33
+// toggle shift+x
+// f for data2 * 2ms
+// A key which is Hold is held down rather than pressed, until the message which matched is released or, if the matcher
+// has a rate matcher, until the matcher no longer matches the message. Unless the matcher has a rate matcher, a message
+// which is a release itself, such as a note off, does not hold the key.
+// A key which is Toggle is held down, or latched, rather than pressed, until the action is performed once more.
+type KeyAction struct {
+	Keycode   int
+	Modifiers Modifiers
+	Hold      bool
+	Toggle    bool
+	// For is how many milliseconds the key is held down for, evaluated against the message which matched, or nil if it
+	// is up to the mapping.
+	For matcher.Expression
+}
+
+// Equal reports whether a and b represent the same action.
+func (a KeyAction) Equal(b Action) bool {
+	bb, ok := b.(KeyAction)
+	if !ok || a.For == nil || bb.For == nil {
+		return ok && a == bb
+	}
+	return a.Key() == bb.Key() && a.Hold == bb.Hold && a.Toggle == bb.Toggle && a.For.Equal(bb.For)
+}
+
+// Key returns the key of a, that is a with only its Keycode and Modifiers.
+func (a KeyAction) Key() KeyAction {
+	return KeyAction{Keycode: a.Keycode, Modifiers: a.Modifiers}
+}
+
+func (_ KeyAction) isAction() {}
+
+// Modifiers are the modifier keys which are held while a key is pressed.
+type Modifiers int
+
+const (
+	CtrlModifier Modifiers = 1 << iota
+	ShiftModifier
+	AltModifier
+	SuperModifier
+)
+
+// LayerAction represents changing which layers are active, such as:
+// layer switch games
+// layer toggle editing
+// layer hold shifted
+type LayerAction struct {
+	Operator LayerOperator
+	Layer    string
+}
+
+// Equal reports whether a and b represent the same action.
+func (a LayerAction) Equal(b Action) bool {
+	bb, ok := b.(LayerAction)
+	return ok && a.Operator == bb.Operator && a.Layer == bb.Layer
+}
+
+func (_ LayerAction) isAction() {}
+
+type LayerOperator int
+
+const (
+	// SwitchLayerOperator deactivates every layer but the base layer, and activates the layer.
+	SwitchLayerOperator LayerOperator = iota
+	// ToggleLayerOperator activates the layer if it is inactive, and deactivates it otherwise.
+	ToggleLayerOperator
+	// HoldLayerOperator activates the layer until the message which matched is released. A message which is a release
+	// itself, such as a note off, does not activate the layer.
+	HoldLayerOperator
+)
+
+// VariableAction represents changing the value of a variable, such as:
+// var set mode = 2
+// var toggle muted
+// var inc mode
+// var dec mode 2
+// Decrementing is represented as incrementing by a negative Value.
+type VariableAction struct {
+	Operator VariableOperator
+	Variable string
+	Value    int64
+}
+
+// Equal reports whether a and b represent the same action.
+func (a VariableAction) Equal(b Action) bool {
+	bb, ok := b.(VariableAction)
+	return ok && a == bb
+}
+
+func (_ VariableAction) isAction() {}
+
+type VariableOperator int
+
+const (
+	// SetVariableOperator sets the variable to Value.
+	SetVariableOperator VariableOperator = iota
+	// ToggleVariableOperator sets a boolean variable to true if it is false, and to false otherwise.
+	ToggleVariableOperator
+	// IncrementVariableOperator adds Value to an integer variable.
+	IncrementVariableOperator
+)
+
+// ListAction represents performing one of a list of actions, selected by Policy each time, such as:
+// cycle(f, j)
+// alternate(e, f, j)
+// random(f, j)
+type ListAction struct {
+	Policy  ListPolicy
+	Actions []Action
+}
+
+// Equal reports whether a and b represent the same action.
+func (a ListAction) Equal(b Action) bool {
+	bb, ok := b.(ListAction)
+	if !ok || a.Policy != bb.Policy || len(a.Actions) != len(bb.Actions) {
+		return false
+	}
+	for i := range a.Actions {
+		if !a.Actions[i].Equal(bb.Actions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (_ ListAction) isAction() {}
+
+type ListPolicy int
+
+const (
+	// CyclePolicy selects the actions in order, starting over with the first after the last.
+	CyclePolicy ListPolicy = iota
+	// AlternatePolicy selects the actions in order and then in reverse order, back and forth, such as e, f, j, f, e, f.
+	AlternatePolicy
+	// RandomPolicy selects any of the actions at random.
+	RandomPolicy
+)
+
+// WaitAction represents waiting for Duration before performing the actions following it, such as:
+// wait 30ms
+type WaitAction struct {
+	Duration time.Duration
+}
+
+// Equal reports whether a and b represent the same action.
+func (a WaitAction) Equal(b Action) bool {
+	bb, ok := b.(WaitAction)
+	return ok && a == bb
+}
+
+func (_ WaitAction) isAction() {}
+
+// ExecAction represents running a command with sh(1), without waiting for it to exit, such as:
+// exec "notify-send don"
+type ExecAction struct {
+	Command string
+}
+
+// Equal reports whether a and b represent the same action.
+func (a ExecAction) Equal(b Action) bool {
+	bb, ok := b.(ExecAction)
+	return ok && a == bb
+}
+
+func (_ ExecAction) isAction() {}
+
+// RepeatAction represents performing Action a number of times, given by Count evaluated against the message which
+// matched, such as:
+// repeat(data2 / 32, f)
+// repeat(3, cycle(f, j))
+// The count must be between 0 and MaxRepeat.
+type RepeatAction struct {
+	Count  matcher.Expression
+	Action Action
+}
+
+// Equal reports whether a and b represent the same action.
+func (a RepeatAction) Equal(b Action) bool {
+	bb, ok := b.(RepeatAction)
+	return ok && a.Count.Equal(bb.Count) && a.Action.Equal(bb.Action)
+}
+
+func (_ RepeatAction) isAction() {}
+
+// MaxRepeat is the largest number of times a RepeatAction performs its action. It is bounded, as no other message is
+// evaluated until the action has been performed every time.
+const MaxRepeat = 128
+
+// ScrollAction represents scrolling the mouse wheel by Amount, evaluated against the message which matched, such as:
+// scroll steps
+// hscroll -data2 / 8
+// Amount is in notches of the wheel, and a positive Amount scrolls up, or right if Horizontal.
+type ScrollAction struct {
+	Amount     matcher.Expression
+	Horizontal bool
+}
+
+// Equal reports whether a and b represent the same action.
+func (a ScrollAction) Equal(b Action) bool {
+	bb, ok := b.(ScrollAction)
+	return ok && a.Amount.Equal(bb.Amount) && a.Horizontal == bb.Horizontal
+}
+
+func (_ ScrollAction) isAction() {}
+
+// ButtonAction represents pressing a button of the virtual gamepad, such as:
+// button 3
+// The button is held down for as long as a key would be.
+type ButtonAction struct {
+	Button int
+}
+
+// MaxButton is the number of buttons of the virtual gamepad, which are numbered from 1. They are the buttons of a
+// gamepad, from the south face button to the right thumb button.
+const MaxButton = 15
+
+// Equal reports whether a and b represent the same action.
+func (a ButtonAction) Equal(b Action) bool {
+	bb, ok := b.(ButtonAction)
+	return ok && a == bb
+}
+
+func (_ ButtonAction) isAction() {}
+
+// AxisAction represents moving an absolute axis of the virtual gamepad to Value, evaluated against the message which
+// matched, such as:
+// axis x = data2
+// axis throttle = 127 - data2
+// The axes range from 0 to 127, like data bytes, and values out of range are clamped.
+type AxisAction struct {
+	Axis  Axis
+	Value matcher.Expression
+}
+
+// Equal reports whether a and b represent the same action.
+func (a AxisAction) Equal(b Action) bool {
+	bb, ok := b.(AxisAction)
+	return ok && a.Axis == bb.Axis && a.Value.Equal(bb.Value)
+}
+
+func (_ AxisAction) isAction() {}
+
+// Axis is an absolute axis of the virtual gamepad.
+type Axis int
+
+const (
+	XAxis Axis = iota
+	YAxis
+	ZAxis
+	RXAxis
+	RYAxis
+	RZAxis
+	ThrottleAxis
+	RudderAxis
+)
+
+// SendAction represents sending Message to the output port, turning it off rather than on if Off, such as:
+// send note 36 velocity 5
+// send off note 36
+// send control 20 value 64 channel 2
+type SendAction struct {
+	Message message.Message
+	Off     bool
+}
+
+// Equal reports whether a and b represent the same action.
+func (a SendAction) Equal(b Action) bool {
+	bb, ok := b.(SendAction)
+	return ok && a == bb
+}
+
+func (_ SendAction) isAction() {}
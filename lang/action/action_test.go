@@ -0,0 +1,322 @@
+package action
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Test that Parse parses a key action correctly.
+func TestParseKey(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := KeyAction{Keycode: 33}
+
+	s := "33"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a layer action correctly.
+func TestParseLayer(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := LayerAction{HoldLayerOperator, "games"}
+
+	s := "layer hold games"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a layer action, with an invalid layer operator, correctly.
+func TestParseLayerInvalidOperator(t *testing.T) {
+	s := "layer push games"
+	wantedErr := fmt.Errorf("action %q: no valid layer operator", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a var action, decrementing a variable, correctly.
+func TestParseVariableDecrement(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := VariableAction{IncrementVariableOperator, "mode", -2}
+
+	s := "var dec mode 2"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a var action, setting a variable without a value, correctly.
+func TestParseVariableSetNoValue(t *testing.T) {
+	s := "var set mode"
+	wantedErr := fmt.Errorf("action %q: var set takes a variable, = and a value", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a key action, holding the key, correctly.
+func TestParseHoldKey(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := KeyAction{Keycode: 33, Hold: true}
+
+	s := "hold 33"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a toggle key action, with modifiers, correctly.
+func TestParseToggleKey(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := KeyAction{Keycode: 45, Modifiers: ShiftModifier, Toggle: true}
+
+	s := "toggle shift+x"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a list action, containing another list action, correctly.
+func TestParseList(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := ListAction{CyclePolicy, []Action{
+		KeyAction{Keycode: 33},
+		ListAction{RandomPolicy, []Action{KeyAction{Keycode: 36}, KeyAction{Keycode: 23}}},
+	}}
+
+	s := "cycle(f, random(j, i))"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that Parse parses a list action, with a single action, correctly.
+func TestParseListSingleAction(t *testing.T) {
+	s := "alternate(f)"
+	wantedErr := fmt.Errorf("action %q: alternate takes at least two actions", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that ParseList parses a list of actions, with a wait, correctly.
+func TestParseListWait(t *testing.T) {
+	var wantedErr error = nil
+	wantedActions := []Action{
+		KeyAction{Keycode: 45, Modifiers: CtrlModifier},
+		WaitAction{30 * time.Millisecond},
+		ListAction{CyclePolicy, []Action{KeyAction{Keycode: 31, Modifiers: CtrlModifier | ShiftModifier}, KeyAction{Keycode: 33}}},
+		ExecAction{"notify-send 'don, ka'"},
+	}
+
+	s := `ctrl+x, wait 30ms, cycle(ctrl+shift+s, f), exec "notify-send 'don, ka'"`
+	actions, err := ParseList(s)
+
+	if err != wantedErr {
+		t.Errorf("ParseList(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if len(actions) != len(wantedActions) {
+		t.Fatalf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+	}
+	for i := range actions {
+		if !actions[i].Equal(wantedActions[i]) {
+			t.Errorf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+		}
+	}
+}
+
+// Test that ParseList parses a list of actions, with expressions, correctly.
+func TestParseListExpressions(t *testing.T) {
+	var wantedErr error = nil
+	wantedActions := []Action{
+		KeyAction{Keycode: 33, For: matcher.BinaryExpression{
+			matcher.OperandExpression{matcher.Data2}, matcher.MultiplyOperator, matcher.Constant{2},
+		}},
+		RepeatAction{
+			matcher.BinaryExpression{matcher.OperandExpression{matcher.Data2}, matcher.DivideOperator, matcher.Constant{32}},
+			ListAction{CyclePolicy, []Action{KeyAction{Keycode: 33}, KeyAction{Keycode: 36}}},
+		},
+	}
+
+	s := "f for data2 * 2ms, repeat(data2 / 32, cycle(f, j))"
+	actions, err := ParseList(s)
+
+	if err != wantedErr {
+		t.Errorf("ParseList(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if len(actions) != len(wantedActions) {
+		t.Fatalf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+	}
+	for i := range actions {
+		if !actions[i].Equal(wantedActions[i]) {
+			t.Errorf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+		}
+	}
+}
+
+// Test that Parse parses a repeat action, which waits, correctly.
+func TestParseRepeatWait(t *testing.T) {
+	s := "repeat(2, wait 30ms)"
+	wantedErr := fmt.Errorf("action %q: repeat cannot wait", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a repeat action, with a count greater than MaxRepeat, correctly.
+func TestParseRepeatCount(t *testing.T) {
+	s := "repeat(1000, f)"
+	wantedErr := fmt.Errorf("action %q: count out of range 0 to %d", s, MaxRepeat)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a horizontal scroll action, with a negated operand, correctly.
+func TestParseHorizontalScroll(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := ScrollAction{
+		matcher.BinaryExpression{
+			matcher.BinaryExpression{matcher.Constant{0}, matcher.SubtractOperator, matcher.OperandExpression{matcher.Steps}},
+			matcher.MultiplyOperator,
+			matcher.Constant{2},
+		},
+		true,
+	}
+
+	s := "hscroll -steps * 2"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
+
+// Test that ParseList parses a list of gamepad actions correctly.
+func TestParseListGamepad(t *testing.T) {
+	var wantedErr error = nil
+	wantedActions := []Action{
+		ButtonAction{3},
+		AxisAction{ThrottleAxis, matcher.BinaryExpression{matcher.Constant{127}, matcher.SubtractOperator, matcher.OperandExpression{matcher.Data2}}},
+	}
+
+	s := "button 3, axis throttle = 127 - data2"
+	actions, err := ParseList(s)
+
+	if err != wantedErr {
+		t.Errorf("ParseList(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if len(actions) != len(wantedActions) {
+		t.Fatalf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+	}
+	for i := range actions {
+		if !actions[i].Equal(wantedActions[i]) {
+			t.Errorf("ParseList(%q) returns incorrect actions %v, want %v.", s, actions, wantedActions)
+		}
+	}
+}
+
+// Test that Parse parses a button action, with a button the gamepad does not have, correctly.
+func TestParseButtonOutOfRange(t *testing.T) {
+	s := "button 16"
+	wantedErr := fmt.Errorf("action %q: no valid button, which is 1 to %d", s, MaxButton)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a send action, turning a note off, correctly.
+func TestParseSendOff(t *testing.T) {
+	var wantedErr error = nil
+	wantedAction := SendAction{message.Message{Kind: message.NoteKind, Channel: 9, Number: 36, Value: 127}, true}
+
+	s := "send off note 36 channel 10"
+	action, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !action.Equal(wantedAction) {
+		t.Errorf("Parse(%q) returns an incorrect action %v, want %v.", s, action, wantedAction)
+	}
+}
@@ -0,0 +1,51 @@
+package device
+
+// builtins are the device profiles shipped with midimap by name.
+var builtins = map[string]string{
+	"gm-drums": gmDrums,
+	"td1":      td1,
+}
+
+// gmDrums names the most common notes of the General MIDI percussion key map, which many electronic drum kits follow.
+const gmDrums = `
+pad kick = 36
+pad side_stick = 37
+pad snare = 38
+pad clap = 39
+pad snare_rim = 40
+pad floor_tom = 41
+pad hihat_closed = 42
+pad floor_tom_high = 43
+pad hihat_pedal = 44
+pad tom_low = 45
+pad hihat_open = 46
+pad tom_mid = 47
+pad tom_high = 48
+pad crash = 49
+pad tom_higher = 50
+pad ride = 51
+pad china = 52
+pad ride_bell = 53
+pad splash = 55
+pad crash2 = 57
+pad ride2 = 59
+`
+
+// td1 names the pads and controls of the Roland TD-1 drum kits, as they are configured out of the box.
+const td1 = `
+pad kick = 36
+pad snare = 38
+pad snare_rim = 40
+pad tom1 = 48
+pad tom2 = 45
+pad tom3 = 43
+pad floor_tom = 43
+pad hihat_closed = 42
+pad hihat_open = 46
+pad hihat_pedal = 44
+pad crash = 49
+pad crash_edge = 55
+pad ride = 51
+pad ride_edge = 59
+control hihat = 4
+`
@@ -0,0 +1,145 @@
+// The device package parses device profiles, which name the pads and controls of a MIDI device so that maps and logs
+// may refer to them by name rather than by number.
+//
+// A device profile consists of lines such as:
+//
+//	# comment
+//	pad snare = 38
+//	control hihat = 4
+//
+// where pads name the notes of the device and controls name its controllers.
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+)
+
+// Device represents a device profile.
+type Device struct {
+	// Pads are the notes of the pads of the device by name.
+	Pads map[string]int64
+	// Controls are the controllers of the controls of the device by name.
+	Controls map[string]int64
+}
+
+// Parse parses a device profile, by parsing every line read from r.
+//
+// If r is a valid device profile, Parse returns device, nil.
+// Otherwise, Parse returns an error describing the first line which is invalid, or the io error which occurred.
+func Parse(r io.Reader) (d Device, err error) {
+	d.Pads = make(map[string]int64)
+	d.Controls = make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		// skip blank lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err = d.parseLine(line); err != nil {
+			err = fmt.Errorf("line %d: %v", lineNumber, err)
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// ParseFile is like Parse, but parses the device profile read from the file named name.
+func ParseFile(name string) (d Device, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	d, err = Parse(f)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return
+}
+
+// parseLine parses a line of a device profile, such as pad snare = 38, into d.
+func (d Device) parseLine(s string) error {
+	fields := strings.Fields(s)
+	if len(fields) != 4 || fields[2] != "=" {
+		return fmt.Errorf("device %q: a line takes pad or control, a name, = and a value", s)
+	}
+	var names map[string]int64
+	switch fields[0] {
+	case "pad":
+		names = d.Pads
+	case "control":
+		names = d.Controls
+	default:
+		return fmt.Errorf("device %q: unknown kind %q", s, fields[0])
+	}
+	name := fields[1]
+	if !matcher.IsIdentifier(name) || matcher.IsReserved(name) || matcher.IsNoteName(name) {
+		return fmt.Errorf("device %q: no valid name", s)
+	}
+	if _, ok := names[name]; ok {
+		return fmt.Errorf("device %q: %s %q already named", s, fields[0], name)
+	}
+	n, err := matcher.DefaultScope.ParseValue(fields[3])
+	if err != nil || n < 0 || n > 127 {
+		return fmt.Errorf("device %q: no valid value", s)
+	}
+	names[name] = n
+	return nil
+}
+
+// PadName returns the name of the pad with a note of n, and whether d has such a pad.
+// If several pads have the note, the first of their names in alphabetical order is returned.
+func (d Device) PadName(n int64) (string, bool) {
+	return nameOf(d.Pads, n)
+}
+
+// ControlName returns the name of the control with a controller of n, and whether d has such a control.
+// If several controls have the controller, the first of their names in alphabetical order is returned.
+func (d Device) ControlName(n int64) (string, bool) {
+	return nameOf(d.Controls, n)
+}
+
+func nameOf(names map[string]int64, n int64) (string, bool) {
+	var matching []string
+	for name, m := range names {
+		if m == n {
+			matching = append(matching, name)
+		}
+	}
+	if matching == nil {
+		return "", false
+	}
+	sort.Strings(matching)
+	return matching[0], true
+}
+
+// Builtin returns the device profile shipped with midimap with a name of name, and whether there is such a profile.
+func Builtin(name string) (Device, bool) {
+	profile, ok := builtins[name]
+	if !ok {
+		return Device{}, false
+	}
+	d, err := Parse(strings.NewReader(profile))
+	if err != nil {
+		panic(fmt.Sprintf("device %s: %v", name, err))
+	}
+	return d, true
+}
+
+// Load returns the device profile shipped with midimap with a name of name if there is one, and otherwise parses the
+// device profile read from the file named name.
+func Load(name string) (Device, error) {
+	if d, ok := Builtin(name); ok {
+		return d, nil
+	}
+	return ParseFile(name)
+}
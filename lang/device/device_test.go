@@ -0,0 +1,60 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Test that Parse parses a simple valid device profile correctly.
+func TestParse(t *testing.T) {
+	var wantedErr error = nil
+	wantedPads := map[string]int64{"snare": 38, "crash": 49}
+	wantedControls := map[string]int64{"hihat": 4}
+
+	s := "# My kit\npad snare = 38\n\npad crash = C#3\ncontrol hihat = 0x04"
+	d, err := Parse(strings.NewReader(s))
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if fmt.Sprint(d.Pads) != fmt.Sprint(wantedPads) || fmt.Sprint(d.Controls) != fmt.Sprint(wantedControls) {
+		t.Errorf("Parse(%q) returns an incorrect device %v, want {%v %v}.", s, d, wantedPads, wantedControls)
+	}
+}
+
+// Test that Parse parses a device profile, naming a pad twice, correctly.
+func TestParseDuplicatePad(t *testing.T) {
+	s := "pad snare = 38\npad snare = 40"
+	wantedErr := fmt.Errorf("line 2: device %q: pad %q already named", "pad snare = 40", "snare")
+
+	_, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that the device profiles shipped with midimap are valid.
+func TestBuiltin(t *testing.T) {
+	for name := range builtins {
+		if _, err := Parse(strings.NewReader(builtins[name])); err != nil {
+			t.Errorf("Builtin(%q) is invalid: %v.", name, err)
+		}
+	}
+}
+
+// Test that PadName returns the first name of a note which several pads have.
+func TestPadName(t *testing.T) {
+	wantedName := "floor_tom"
+
+	d, _ := Builtin("td1")
+	name, ok := d.PadName(43)
+
+	if !ok || name != wantedName {
+		t.Errorf("PadName(%d) returns an incorrect name %q, %v, want %q, true.", 43, name, ok, wantedName)
+	}
+}
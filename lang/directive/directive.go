@@ -0,0 +1,549 @@
+// The directive package parses directives, that is the lines of a map which configure the map as a whole rather than describing a mapping.
+package directive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/matcher"
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Parse parses a directive as specified in Section 1.3 DIRECTIVES of the midimap-lang specification.
+//
+// If s is a valid directive as described by the specification, Parse returns directive, nil.
+// Otherwise, Parse returns an error describing why the directive is invalid.
+// s may not contain any leading or trailing space characters.
+func Parse(s string) (Directive, error) {
+	return ParseInScope(s, matcher.DefaultScope)
+}
+
+// ParseInScope is like Parse, but parses the values and matchers of s in scope rather than in the default scope.
+func ParseInScope(s string, scope matcher.Scope) (Directive, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("directive %q: empty", s)
+	}
+
+	switch fields[0] {
+	case "evaluate":
+		return parseEvaluationDirective(s, fields[1:])
+	case "layer":
+		return parseLayerDirective(s, fields[1:])
+	case "var":
+		return parseVariableDirective(s, fields[1:])
+	case "middle-c":
+		return parseMiddleCDirective(s, fields[1:])
+	case "let":
+		return parseLetDirective(s, fields[1:], scope)
+	case "def":
+		return parseDefDirective(s, scope)
+	case "include":
+		return parseIncludeDirective(s)
+	case "device":
+		return parseDeviceDirective(s, fields[1:])
+	case "hold":
+		return parseHoldDirective(s, fields[1:])
+	case "encoder":
+		return parseEncoderDirective(s, fields[1:], scope)
+	case "feedback":
+		return parseFeedbackDirective(s, fields[1:])
+	default:
+		return nil, fmt.Errorf("directive %q: unknown directive %q", s, fields[0])
+	}
+}
+
+// parseEvaluationDirective parses the arguments of an evaluate directive.
+func parseEvaluationDirective(s string, args []string) (d EvaluationDirective, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("directive %q: evaluate takes exactly one argument", s)
+		return
+	}
+	switch args[0] {
+	case "all":
+		d.Mode = AllEvaluation
+	case "first":
+		d.Mode = FirstEvaluation
+	default:
+		err = fmt.Errorf("directive %q: no valid evaluation mode", s)
+	}
+	return
+}
+
+// parseLayerDirective parses the arguments of a layer directive.
+func parseLayerDirective(s string, args []string) (d LayerDirective, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("directive %q: layer takes exactly one argument", s)
+		return
+	}
+	d.Name = args[0]
+	return
+}
+
+// parseVariableDirective parses the arguments of a var directive.
+func parseVariableDirective(s string, args []string) (d VariableDirective, err error) {
+	if len(args) != 2 && (len(args) != 4 || args[2] != "=") {
+		err = fmt.Errorf("directive %q: var takes a name, a type and optionally = and a value", s)
+		return
+	}
+	d.Name = args[0]
+	if !isName(d.Name) {
+		err = fmt.Errorf("directive %q: no valid variable name", s)
+		return
+	}
+	switch args[1] {
+	case "bool":
+		d.Type = BoolType
+	case "int":
+		d.Type = IntType
+	default:
+		err = fmt.Errorf("directive %q: no valid variable type", s)
+		return
+	}
+	if len(args) == 2 {
+		return
+	}
+
+	switch {
+	case d.Type == BoolType && args[3] == "true":
+		d.Value = 1
+	case d.Type == BoolType && args[3] == "false":
+		d.Value = 0
+	case d.Type == IntType:
+		d.Value, err = matcher.ParseInteger(args[3])
+		if err == nil {
+			return
+		}
+		fallthrough
+	default:
+		err = fmt.Errorf("directive %q: no valid value", s)
+	}
+	return
+}
+
+// parseMiddleCDirective parses the arguments of a middle-c directive.
+func parseMiddleCDirective(s string, args []string) (d MiddleCDirective, err error) {
+	if len(args) != 1 || !strings.HasPrefix(args[0], "C") {
+		err = fmt.Errorf("directive %q: middle-c takes exactly one argument, such as C3 or C4", s)
+		return
+	}
+	d.Octave, err = matcher.ParseInteger(args[0][1:])
+	if err != nil {
+		err = fmt.Errorf("directive %q: no valid octave", s)
+	}
+	return
+}
+
+// parseLetDirective parses the arguments of a let directive.
+func parseLetDirective(s string, args []string, scope matcher.Scope) (d LetDirective, err error) {
+	if len(args) != 3 || args[1] != "=" {
+		err = fmt.Errorf("directive %q: let takes a name, = and a value", s)
+		return
+	}
+	d.Name = args[0]
+	if !isName(d.Name) {
+		err = fmt.Errorf("directive %q: no valid name", s)
+		return
+	}
+	d.Value, err = scope.ParseValue(args[2])
+	if err != nil {
+		err = fmt.Errorf("directive %q: %v", s, err)
+	}
+	return
+}
+
+// parseDefDirective parses a def directive.
+func parseDefDirective(s string, scope matcher.Scope) (d DefDirective, err error) {
+	definition := strings.TrimSpace(strings.TrimPrefix(s, "def"))
+	i := strings.Index(definition, "=")
+	if i == -1 {
+		err = fmt.Errorf("directive %q: def takes a name, = and a matcher", s)
+		return
+	}
+	d.Name = strings.TrimSpace(definition[:i])
+	if !isName(d.Name) {
+		err = fmt.Errorf("directive %q: no valid name", s)
+		return
+	}
+	d.Matcher, err = scope.Parse(strings.TrimSpace(definition[i+1:]))
+	return
+}
+
+// parseIncludeDirective parses an include directive.
+func parseIncludeDirective(s string) (d IncludeDirective, err error) {
+	path := strings.TrimSpace(strings.TrimPrefix(s, "include"))
+	d.Path, err = strconv.Unquote(path)
+	if err != nil || !strings.HasPrefix(path, `"`) || d.Path == "" {
+		err = fmt.Errorf("directive %q: include takes exactly one argument, a quoted file name", s)
+	}
+	return
+}
+
+// parseDeviceDirective parses a device directive.
+func parseDeviceDirective(s string, args []string) (d DeviceDirective, err error) {
+	profile := strings.TrimSpace(strings.TrimPrefix(s, "device"))
+	if strings.HasPrefix(profile, `"`) {
+		d.Path, err = strconv.Unquote(profile)
+		if err != nil || d.Path == "" {
+			err = fmt.Errorf("directive %q: no valid file name", s)
+		}
+		return
+	}
+	if len(args) != 1 {
+		err = fmt.Errorf("directive %q: device takes exactly one argument, the name of a device or a quoted file name", s)
+		return
+	}
+	d.Name = args[0]
+	return
+}
+
+// parseHoldDirective parses the arguments of a hold directive.
+func parseHoldDirective(s string, args []string) (d HoldDirective, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("directive %q: hold takes exactly one argument", s)
+		return
+	}
+	d.Duration, err = matcher.ParseDuration(args[0])
+	if err != nil {
+		err = fmt.Errorf("directive %q: no valid duration", s)
+	}
+	return
+}
+
+// encodings are the encodings of encoders by name.
+var encodings = map[string]Encoding{
+	"twos-complement": TwosComplementEncoding,
+	"offset":          OffsetEncoding,
+	"sign-magnitude":  SignMagnitudeEncoding,
+}
+
+// parseEncoderDirective parses the arguments of an encoder directive.
+func parseEncoderDirective(s string, args []string, scope matcher.Scope) (d EncoderDirective, err error) {
+	if len(args) != 2 && (len(args) != 4 || args[2] != "accel") {
+		err = fmt.Errorf("directive %q: encoder takes a control, an encoding and optionally accel and a factor", s)
+		return
+	}
+	d.Control, err = scope.ParseControl(args[0])
+	if err != nil {
+		err = fmt.Errorf("directive %q: %v", s, err)
+		return
+	}
+	var ok bool
+	d.Encoding, ok = encodings[args[1]]
+	if !ok {
+		err = fmt.Errorf("directive %q: no valid encoding", s)
+		return
+	}
+	d.Acceleration = 1
+	if len(args) == 4 {
+		d.Acceleration, err = matcher.ParseInteger(args[3])
+		if err != nil || d.Acceleration < 1 {
+			err = fmt.Errorf("directive %q: no valid acceleration factor", s)
+		}
+	}
+	return
+}
+
+// feedbackKinds are the kinds of state which feedback directives mirror by name.
+var feedbackKinds = map[string]FeedbackKind{
+	"layer":  LayerFeedback,
+	"var":    VariableFeedback,
+	"toggle": ToggleFeedback,
+}
+
+// parseFeedbackDirective parses the arguments of a feedback directive.
+func parseFeedbackDirective(s string, args []string) (d FeedbackDirective, err error) {
+	if len(args) < 4 {
+		err = fmt.Errorf("directive %q: feedback takes layer, var or toggle, a name and a message", s)
+		return
+	}
+	var ok bool
+	d.Kind, ok = feedbackKinds[args[0]]
+	if !ok {
+		err = fmt.Errorf("directive %q: unknown kind %q", s, args[0])
+		return
+	}
+	d.Name = args[1]
+	if d.Kind == ToggleFeedback {
+		var a action.Action
+		a, err = action.Parse("toggle " + d.Name)
+		if err != nil {
+			err = fmt.Errorf("directive %q: %v", s, err)
+			return
+		}
+		d.Key = a.(action.KeyAction).Key()
+	}
+	d.Message, err = message.Parse(strings.Join(args[2:], " "))
+	if err != nil {
+		err = fmt.Errorf("directive %q: %v", s, err)
+	}
+	return
+}
+
+// isName reports whether s may be declared as the name of a variable, constant or definition.
+func isName(s string) bool {
+	return matcher.IsIdentifier(s) && !matcher.IsReserved(s) && !matcher.IsNoteName(s)
+}
+
+// Directive is a discriminated union of all the directives.
+//
+// See the Matcher type of the matcher package for an explanation of how discriminated unions are represented.
+type Directive interface {
+	isDirective()
+	Equal(Directive) bool
+}
+
+// EvaluationDirective represents an evaluate directive, such as:
+// evaluate all
+// evaluate first
+type EvaluationDirective struct {
+	Mode EvaluationMode
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d EvaluationDirective) Equal(e Directive) bool {
+	ee, ok := e.(EvaluationDirective)
+	return ok && d.Mode == ee.Mode
+}
+
+func (_ EvaluationDirective) isDirective() {}
+
+// EvaluationMode decides which of the mappings matching a message are acted upon.
+type EvaluationMode int
+
+const (
+	// AllEvaluation acts upon every matching mapping, unless a mapping says to stop.
+	AllEvaluation EvaluationMode = iota
+	// FirstEvaluation acts upon the first matching mapping only, unless a mapping says to continue.
+	FirstEvaluation
+)
+
+// LayerDirective represents a layer directive, such as:
+// layer games
+// The mappings following a layer directive belong to the layer it names.
+type LayerDirective struct {
+	Name string
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d LayerDirective) Equal(e Directive) bool {
+	ee, ok := e.(LayerDirective)
+	return ok && d.Name == ee.Name
+}
+
+func (_ LayerDirective) isDirective() {}
+
+// VariableDirective represents a var directive, such as:
+// var mode int = 2
+// var muted bool
+// The initial Value of a boolean variable is 1 if it is true and 0 otherwise.
+type VariableDirective struct {
+	Name  string
+	Type  VariableType
+	Value int64
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d VariableDirective) Equal(e Directive) bool {
+	ee, ok := e.(VariableDirective)
+	return ok && d == ee
+}
+
+func (_ VariableDirective) isDirective() {}
+
+type VariableType int
+
+const (
+	IntType VariableType = iota
+	BoolType
+)
+
+// MiddleCDirective represents a middle-c directive, such as:
+// middle-c C3
+// It decides which octave note names of the mappings following it are in, by naming middle C, that is note 60.
+type MiddleCDirective struct {
+	Octave int64
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d MiddleCDirective) Equal(e Directive) bool {
+	ee, ok := e.(MiddleCDirective)
+	return ok && d == ee
+}
+
+func (_ MiddleCDirective) isDirective() {}
+
+// LetDirective represents a let directive, such as:
+// let snare = 38
+// let crash = C#3
+// It defines a constant, which matchers may compare operands to.
+type LetDirective struct {
+	Name  string
+	Value int64
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d LetDirective) Equal(e Directive) bool {
+	ee, ok := e.(LetDirective)
+	return ok && d == ee
+}
+
+func (_ LetDirective) isDirective() {}
+
+// DefDirective represents a def directive, such as:
+// def hard = data2 >= 80
+// It defines a matcher, which may be used in place of a matcher by its name.
+type DefDirective struct {
+	Name    string
+	Matcher matcher.Matcher
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d DefDirective) Equal(e Directive) bool {
+	ee, ok := e.(DefDirective)
+	return ok && d.Name == ee.Name && d.Matcher.Equal(ee.Matcher)
+}
+
+func (_ DefDirective) isDirective() {}
+
+// IncludeDirective represents an include directive, such as:
+// include "td1-pads.mml"
+// The lines of the file named Path, relative to the directory of the including file, are parsed as if they were a part
+// of the including file.
+type IncludeDirective struct {
+	Path string
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d IncludeDirective) Equal(e Directive) bool {
+	ee, ok := e.(IncludeDirective)
+	return ok && d == ee
+}
+
+func (_ IncludeDirective) isDirective() {}
+
+// DeviceDirective represents a device directive, such as:
+// device td1
+// device "my-kit.mmd"
+// It names the device profile of the map, which is either shipped with midimap and named Name, or the file named
+// Path, relative to the directory of the file containing the directive.
+type DeviceDirective struct {
+	Name string
+	Path string
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d DeviceDirective) Equal(e Directive) bool {
+	ee, ok := e.(DeviceDirective)
+	return ok && d == ee
+}
+
+func (_ DeviceDirective) isDirective() {}
+
+// HoldDirective represents a hold directive, such as:
+// hold 16ms
+// It gives how long the keys pressed by the mappings of the map are held down, unless a mapping says otherwise, as some
+// programs miss keys which are released right away.
+type HoldDirective struct {
+	Duration time.Duration
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d HoldDirective) Equal(e Directive) bool {
+	ee, ok := e.(HoldDirective)
+	return ok && d == ee
+}
+
+func (_ HoldDirective) isDirective() {}
+
+// EncoderDirective represents an encoder directive, such as:
+// encoder 16 twos-complement
+// encoder knob1 offset accel 4
+// It declares the controller numbered Control to be an endless encoder, which sends how many steps it is turned by in
+// Encoding rather than an absolute value. Turning it by more than one step at once, that is quickly, counts Acceleration
+// times as many steps.
+type EncoderDirective struct {
+	Control      int64
+	Encoding     Encoding
+	Acceleration int64
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d EncoderDirective) Equal(e Directive) bool {
+	ee, ok := e.(EncoderDirective)
+	return ok && d == ee
+}
+
+func (_ EncoderDirective) isDirective() {}
+
+// Steps returns how many steps the encoder is turned by when it sends value, including acceleration. The steps are
+// positive when it is turned clockwise, and negative otherwise.
+func (d EncoderDirective) Steps(value int64) int64 {
+	var n int64
+	switch d.Encoding {
+	case TwosComplementEncoding:
+		n = value
+		if value >= 64 {
+			n = value - 128
+		}
+	case OffsetEncoding:
+		n = value - 64
+	case SignMagnitudeEncoding:
+		n = value & 63
+		if value&64 != 0 {
+			n = -n
+		}
+	default:
+		panic("unreachable")
+	}
+	if n > 1 || n < -1 {
+		n *= d.Acceleration
+	}
+	return n
+}
+
+// Encoding is how an encoder encodes the steps it is turned by in data2.
+type Encoding int
+
+const (
+	// TwosComplementEncoding encodes clockwise steps as 1, 2, ... and counterclockwise steps as 127, 126, ...
+	TwosComplementEncoding Encoding = iota
+	// OffsetEncoding encodes clockwise steps as 65, 66, ... and counterclockwise steps as 63, 62, ...
+	OffsetEncoding
+	// SignMagnitudeEncoding encodes clockwise steps as 1, 2, ... and counterclockwise steps as 65, 66, ...
+	SignMagnitudeEncoding
+)
+
+// FeedbackDirective represents a feedback directive, such as:
+// feedback layer games note 36
+// feedback var muted control 20 value 64
+// feedback toggle shift note 37 velocity 5 channel 10
+// It mirrors state of the map onto the device, by sending Message to turn it on while the layer named Name is active,
+// the variable named Name is not 0 or the key Key is latched, and to turn it off otherwise.
+type FeedbackDirective struct {
+	Kind FeedbackKind
+	// Name is the name of the layer or variable, or the key as written.
+	Name    string
+	Key     action.KeyAction
+	Message message.Message
+}
+
+// Equal reports whether d and e represent the same directive.
+func (d FeedbackDirective) Equal(e Directive) bool {
+	ee, ok := e.(FeedbackDirective)
+	return ok && d == ee
+}
+
+func (_ FeedbackDirective) isDirective() {}
+
+// FeedbackKind is the kind of state a feedback directive mirrors.
+type FeedbackKind int
+
+const (
+	LayerFeedback FeedbackKind = iota
+	VariableFeedback
+	ToggleFeedback
+)
@@ -0,0 +1,296 @@
+package directive
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/matcher"
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Test that Parse parses a simple valid evaluate directive correctly.
+func TestParseEvaluation(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := EvaluationDirective{FirstEvaluation}
+
+	s := "evaluate first"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses an evaluate directive, with an invalid evaluation mode, correctly.
+func TestParseEvaluationInvalidMode(t *testing.T) {
+	s := "evaluate some"
+	wantedErr := fmt.Errorf("directive %q: no valid evaluation mode", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses an unknown directive correctly.
+func TestParseUnknown(t *testing.T) {
+	s := "evaluation all"
+	wantedErr := fmt.Errorf("directive %q: unknown directive %q", s, "evaluation")
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a layer directive, lacking a name, correctly.
+func TestParseLayerNoName(t *testing.T) {
+	s := "layer"
+	wantedErr := fmt.Errorf("directive %q: layer takes exactly one argument", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a var directive correctly.
+func TestParseVariable(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := VariableDirective{"muted", BoolType, 1}
+
+	s := "var muted bool = true"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses a var directive, with a value of the wrong type, correctly.
+func TestParseVariableInvalidValue(t *testing.T) {
+	s := "var muted bool = 2"
+	wantedErr := fmt.Errorf("directive %q: no valid value", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a middle-c directive correctly.
+func TestParseMiddleC(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := MiddleCDirective{3}
+
+	s := "middle-c C3"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses a let directive, defining a constant as another constant, correctly.
+func TestParseLet(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := LetDirective{"snare", 38}
+
+	s := "let snare = d"
+	directive, err := ParseInScope(s, matcher.Scope{Constants: map[string]int64{"d": 38}})
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses a let directive, naming a constant like a note, correctly.
+func TestParseLetNoteName(t *testing.T) {
+	s := "let C2 = 38"
+	wantedErr := fmt.Errorf("directive %q: no valid name", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a def directive correctly.
+func TestParseDef(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := DefDirective{"hard", matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data2, Operator: matcher.GreaterThanOrEqualToOperator, RightOperand: 80}}
+
+	s := "def hard = data2 >= 80"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses an include directive correctly.
+func TestParseInclude(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := IncludeDirective{"td1 pads.mml"}
+
+	s := `include "td1 pads.mml"`
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses an include directive, with an unquoted file name, correctly.
+func TestParseIncludeUnquoted(t *testing.T) {
+	s := "include td1-pads.mml"
+	wantedErr := fmt.Errorf("directive %q: include takes exactly one argument, a quoted file name", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a device directive, naming a file, correctly.
+func TestParseDevice(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := DeviceDirective{Path: "my-kit.mmd"}
+
+	s := `device "my-kit.mmd"`
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Parse parses a hold directive correctly.
+func TestParseHold(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := HoldDirective{16 * time.Millisecond}
+
+	s := "hold 16ms"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that ParseInScope parses an encoder directive, naming a control of the scope, correctly.
+func TestParseEncoder(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := EncoderDirective{Control: 16, Encoding: OffsetEncoding, Acceleration: 4}
+
+	scope := matcher.DefaultScope
+	scope.Controls = map[string]int64{"knob1": 16}
+	s := "encoder knob1 offset accel 4"
+	directive, err := ParseInScope(s, scope)
+
+	if err != wantedErr {
+		t.Errorf("ParseInScope(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("ParseInScope(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
+
+// Test that Steps decodes the values of encoders, in every encoding, correctly.
+func TestEncoderSteps(t *testing.T) {
+	for _, c := range []struct {
+		encoding Encoding
+		value    int64
+		steps    int64
+	}{
+		{TwosComplementEncoding, 1, 1},
+		{TwosComplementEncoding, 127, -1},
+		{TwosComplementEncoding, 125, -6},
+		{OffsetEncoding, 65, 1},
+		{OffsetEncoding, 63, -1},
+		{OffsetEncoding, 67, 6},
+		{SignMagnitudeEncoding, 1, 1},
+		{SignMagnitudeEncoding, 65, -1},
+		{SignMagnitudeEncoding, 66, -4},
+	} {
+		d := EncoderDirective{Encoding: c.encoding, Acceleration: 2}
+		if steps := d.Steps(c.value); steps != c.steps {
+			t.Errorf("%v.Steps(%d) returns an incorrect number of steps %d, want %d.", d, c.value, steps, c.steps)
+		}
+	}
+}
+
+// Test that Parse parses a feedback directive, mirroring a latched key, correctly.
+func TestParseFeedbackToggle(t *testing.T) {
+	var wantedErr error = nil
+	wantedDirective := FeedbackDirective{
+		Kind:    ToggleFeedback,
+		Name:    "shift+x",
+		Key:     action.KeyAction{Keycode: 45, Modifiers: action.ShiftModifier},
+		Message: message.Message{Kind: message.NoteKind, Channel: 9, Number: 37, Value: 5},
+	}
+
+	s := "feedback toggle shift+x note 37 velocity 5 channel 10"
+	directive, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !directive.Equal(wantedDirective) {
+		t.Errorf("Parse(%q) returns an incorrect directive %v, want %v.", s, directive, wantedDirective)
+	}
+}
@@ -14,5 +14,39 @@ func BeforeAndAfter(r *regexp.Regexp, s string) (string, string, bool) {
 
 	before, after := s[:loc[0]], s[loc[1]:]
 	return before, after, true
+}
 
+// BeforeAndAfterOutsideBrackets is like BeforeAndAfter, except it ignores the matches of r which are enclosed in
+// parentheses, braces or double quotes, such as the comma in {37, 38}.
+func BeforeAndAfterOutsideBrackets(r *regexp.Regexp, s string) (string, string, bool) {
+	for _, loc := range r.FindAllStringIndex(s, -1) {
+		if !enclosed(s[:loc[0]]) {
+			return s[:loc[0]], s[loc[1]:], true
+		}
+	}
+	return "", "", false
+}
+
+// enclosed reports whether the end of s is enclosed in parentheses, braces or double quotes, that is whether some are
+// opened but not closed in s.
+func enclosed(s string) bool {
+	depth := 0
+	quoted := false
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(', c == '{':
+			depth++
+		case c == ')', c == '}':
+			depth--
+		}
+	}
+	return depth != 0 || quoted
 }
@@ -5,12 +5,16 @@ import (
 	"strconv"
 )
 
-// Parse parses a keycode as specified in Section 1.2.2 KEYCODES of the midimap-lang specification.
+// Parse parses a keycode as specified in Section 1.2.2.1 KEYCODES of the midimap-lang specification.
+// Besides integers, a keycode may be the name of a key, such as f, space or f13.
 //
 // If s is a valid keycode as described by the specification, Parse returns keycode, nil.
 // Otherwise, Parse returns an error describing why the keycode is invalid.
 // s may not contain any leading or trailing spaces.
 func Parse(s string) (int, error) {
+	if keycode, ok := names[s]; ok {
+		return keycode, nil
+	}
 	keycode, err := strconv.Atoi(s)
 	if err != nil {
 		return keycode, fmt.Errorf("keycode %q: invalid", s)
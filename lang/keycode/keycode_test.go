@@ -35,3 +35,20 @@ func TestParseInterspersedSpaces(t *testing.T) {
 		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
 	}
 }
+
+// Test that Parse parses the name of a key correctly.
+func TestParseName(t *testing.T) {
+	var wantedErr error = nil
+	wantedKeycode := 33
+
+	s := "f"
+	keycode, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if keycode != wantedKeycode {
+		t.Errorf("Parse(%q) returns an incorrect keycode %d, want %d.", s, keycode, wantedKeycode)
+	}
+}
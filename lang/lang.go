@@ -3,30 +3,533 @@ package lang
 
 import (
 	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/device"
+	"github.com/fossegrim/midimap/lang/directive"
 	"github.com/fossegrim/midimap/lang/mapping"
+	"github.com/fossegrim/midimap/lang/matcher"
 )
 
-// NextMapping parses a mapping as specified in Section 1.2 MAPPINGS of the midimap-lang specification, by parsing lines until a mapping is reached or an io error occurs.
+// BaseLayer is the name of the layer which the mappings preceding every layer directive belong to.
+const BaseLayer = "base"
+
+// Map represents a map as specified in Section 1 MAPS of the midimap-lang specification.
+type Map struct {
+	// Evaluation is the evaluation mode given by the evaluate directive of the map, or directive.AllEvaluation if it has none.
+	Evaluation directive.EvaluationMode
+	// Hold is how long the keys pressed by the mappings of the map are held down, as given by the hold directive of the
+	// map, or 0 if it has none.
+	Hold time.Duration
+	// Layers are the layers of the map in the order they are first named. The first layer is always the base layer.
+	Layers []Layer
+	// Variables are the variables declared by the var directives of the map, in the order they are declared.
+	Variables []directive.VariableDirective
+	// Encoders are the encoders declared by the encoder directives of the map, in the order they are declared.
+	Encoders []directive.EncoderDirective
+	// Feedback are the feedback directives of the map, in the order they are given.
+	Feedback []directive.FeedbackDirective
+	// Files are the names of the files the map was read from, in the order they are first read, starting with the file
+	// given to ParseFile, if any. They include the device profile of the map, if it is read from a file.
+	Files []string
+}
+
+// Layer is a named list of mappings, which are only evaluated while the layer is active.
+type Layer struct {
+	Name     string
+	Mappings []mapping.Mapping
+}
+
+// LayerIndex returns the index of the layer named name in m.Layers, or -1 if m has no such layer.
+func (m Map) LayerIndex(name string) int {
+	for i, l := range m.Layers {
+		if l.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Variable returns the declaration of the variable named name, and whether m declares such a variable.
+func (m Map) Variable(name string) (directive.VariableDirective, bool) {
+	for _, v := range m.Variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return directive.VariableDirective{}, false
+}
+
+// Encoder returns the declaration of the encoder which is the controller numbered control, and whether m declares such
+// an encoder.
+func (m Map) Encoder(control int64) (directive.EncoderDirective, bool) {
+	for _, e := range m.Encoders {
+		if e.Control == control {
+			return e, true
+		}
+	}
+	return directive.EncoderDirective{}, false
+}
+
+// Position is the position of a line of a map.
+type Position struct {
+	// File is the name of the file containing the line, or "" if the line is a part of the map given to Parse.
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s: line %d", p.File, p.Line)
+}
+
+// Error describes a line of a map which could not be parsed.
+type Error struct {
+	File string
+	Line int
+	Err  error
+	// Includes are the positions of the include directives through which the file containing the line was included,
+	// from the innermost to the outermost.
+	Includes []Position
+	// order is the number of lines read before the line, which orders the errors of a map.
+	order int
+}
+
+func (e Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: %v", Position{e.File, e.Line}, e.Err)
+	for _, p := range e.Includes {
+		fmt.Fprintf(&b, ", included from %v", p)
+	}
+	return b.String()
+}
+
+// ErrorList is a list of Errors, in the order of the lines they describe.
+type ErrorList []Error
+
+func (l ErrorList) Error() string {
+	var b strings.Builder
+	for i, e := range l {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// Parse parses a map as specified in Section 1 MAPS of the midimap-lang specification, by parsing every line read from r.
+// The files included by the map are named relative to the working directory.
 //
-// If an io error occurs, NextMapping returns the io error.
-// If a parsing error occurs, NextMapping returns the parsing error.
-// Otherwise, NextMapping returns m, nil.
-func NextMapping(r *bufio.Reader) (m mapping.Mapping, err error) {
-	var line string
-	for {
-		var s string
-		s, err = r.ReadString('\n')
+// If an io error occurs, Parse returns the io error.
+// If some lines cannot be parsed, Parse returns a map of the lines which could be parsed along with an ErrorList describing the others.
+// Otherwise, Parse returns m, nil.
+func Parse(r io.Reader) (Map, error) {
+	return parse(r, "")
+}
+
+// ParseFile is like Parse, but parses the map read from the file named name, whose included files are named relative
+// to the directory of name.
+// The name of the file is included in m.Files.
+func ParseFile(name string) (m Map, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return parse(f, name)
+}
+
+// parse parses the map read from r, which is read from the file named name unless name is "".
+func parse(r io.Reader, name string) (m Map, err error) {
+	p := parser{scope: matcher.DefaultScope, names: make(map[string]Position), encoderLines: make(map[int64]Position),
+		included: make(map[string]bool)}
+	p.scope.Constants = make(map[string]int64)
+	p.scope.Definitions = make(map[string]matcher.Matcher)
+	m.Layers = []Layer{{Name: BaseLayer}}
+	if err = p.parseFile(&m, r, name); err != nil {
+		return
+	}
+
+	p.addMappings(&m)
+	if p.errs != nil {
+		err = p.errs
+	}
+	return
+}
+
+// parser holds the state of Parse which is not a part of the map itself.
+type parser struct {
+	errs           ErrorList
+	evaluationLine Position
+	holdLine       Position
+	deviceLine     Position
+	// layer is the index of the layer which the next mapping belongs to.
+	layer int
+	// mappings are the mappings of the map along with the lines they are on, and so are feedback the feedback
+	// directives, as the layers and variables they name may be declared after them.
+	mappings []lineMapping
+	feedback []lineFeedback
+	// names are the positions of the lines which the variables, constants and definitions of the map are declared on.
+	names map[string]Position
+	// encoderLines are the positions of the lines which the encoders of the map are declared on, by control.
+	encoderLines map[int64]Position
+	// scope is the scope which the next line is parsed in.
+	scope matcher.Scope
+	// files are the names of the files which are being parsed, from the outermost to the innermost, and includes the
+	// positions of the include directives which included all but the outermost.
+	files    []string
+	includes []Position
+	// included are the absolute names of the files which have been included.
+	included map[string]bool
+	// read is the number of lines read so far.
+	read int
+}
+
+type lineFeedback struct {
+	err      Error
+	feedback directive.FeedbackDirective
+}
+
+type lineMapping struct {
+	err Error
+	// layer is the index of the layer which the mapping belongs to.
+	layer   int
+	mapping mapping.Mapping
+}
+
+// parseFile parses every line read from r into m, which is read from the file named name unless name is "".
+func (p *parser) parseFile(m *Map, r io.Reader, name string) error {
+	if name != "" {
+		m.Files = append(m.Files, name)
+		if abs, err := filepath.Abs(name); err == nil {
+			p.included[abs] = true
+		}
+	}
+	p.files = append(p.files, name)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		p.read++
+		line := strings.TrimSpace(scanner.Text())
+		// skip blank lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.parseLine(m, lineNumber, line)
+	}
+	return scanner.Err()
+}
+
+// error returns an Error describing err, which is the problem of the line with a number of lineNumber of the file
+// being parsed.
+func (p *parser) error(lineNumber int, err error) Error {
+	e := Error{File: p.files[len(p.files)-1], Line: lineNumber, Err: err, order: p.read}
+	for i := len(p.includes) - 1; i >= 0; i-- {
+		e.Includes = append(e.Includes, p.includes[i])
+	}
+	return e
+}
+
+// position returns the position of the line with a number of lineNumber of the file being parsed.
+func (p *parser) position(lineNumber int) Position {
+	return Position{p.files[len(p.files)-1], lineNumber}
+}
+
+// parseLine parses the line with a number of lineNumber into m.
+func (p *parser) parseLine(m *Map, lineNumber int, line string) {
+	// Mappings are the only lines containing a separator.
+	if strings.Contains(line, "->") {
+		mp, err := mapping.ParseInScope(line, p.scope)
+		if err != nil {
+			p.errs = append(p.errs, p.error(lineNumber, err))
+			return
+		}
+		p.mappings = append(p.mappings, lineMapping{p.error(lineNumber, nil), p.layer, mp})
+		return
+	}
+
+	d, err := directive.ParseInScope(line, p.scope)
+	if err != nil {
+		p.errs = append(p.errs, p.error(lineNumber, err))
+		return
+	}
+	switch d := d.(type) {
+	case directive.EvaluationDirective:
+		if p.evaluationLine.Line != 0 {
+			p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("evaluation mode already given on %v", p.relative(p.evaluationLine))))
+			return
+		}
+		p.evaluationLine = p.position(lineNumber)
+		m.Evaluation = d.Mode
+	case directive.LayerDirective:
+		p.layer = m.LayerIndex(d.Name)
+		if p.layer == -1 {
+			p.layer = len(m.Layers)
+			m.Layers = append(m.Layers, Layer{Name: d.Name})
+		}
+	case directive.VariableDirective:
+		if !p.declare(lineNumber, "variable", d.Name) {
+			return
+		}
+		m.Variables = append(m.Variables, d)
+	case directive.LetDirective:
+		if !p.declare(lineNumber, "constant", d.Name) {
+			return
+		}
+		p.scope.Constants[d.Name] = d.Value
+	case directive.DefDirective:
+		if !p.declare(lineNumber, "definition", d.Name) {
+			return
+		}
+		p.scope.Definitions[d.Name] = d.Matcher
+	case directive.MiddleCDirective:
+		p.scope.MiddleCOctave = d.Octave
+	case directive.HoldDirective:
+		if p.holdLine.Line != 0 {
+			p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("hold duration already given on %v", p.relative(p.holdLine))))
+			return
+		}
+		p.holdLine = p.position(lineNumber)
+		m.Hold = d.Duration
+	case directive.EncoderDirective:
+		if pos, ok := p.encoderLines[d.Control]; ok {
+			p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("encoder %d already declared on %v", d.Control, p.relative(pos))))
+			return
+		}
+		p.encoderLines[d.Control] = p.position(lineNumber)
+		m.Encoders = append(m.Encoders, d)
+	case directive.FeedbackDirective:
+		p.feedback = append(p.feedback, lineFeedback{p.error(lineNumber, nil), d})
+	case directive.IncludeDirective:
+		p.include(m, lineNumber, d.Path)
+	case directive.DeviceDirective:
+		p.useDevice(m, lineNumber, d)
+	default:
+		panic("unreachable")
+	}
+}
+
+// declare declares the variable, constant or definition named name on the line with a number of lineNumber, and
+// reports whether it could be, as names may only be declared once.
+func (p *parser) declare(lineNumber int, kind string, name string) bool {
+	if pos, ok := p.names[name]; ok {
+		p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("%s %q already declared on %v", kind, name, p.relative(pos))))
+		return false
+	}
+	p.names[name] = p.position(lineNumber)
+	return true
+}
+
+// relative returns pos without its file name if it is a line of the file being parsed.
+func (p *parser) relative(pos Position) Position {
+	if pos.File == p.files[len(p.files)-1] {
+		pos.File = ""
+	}
+	return pos
+}
+
+// resolve returns the name of the file named path relative to the directory of the file being parsed.
+func (p *parser) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(p.files[len(p.files)-1]), path)
+}
+
+// useDevice makes the pads and controls of the device profile named by d available to the lines following the
+// device directive d, which is on the line with a number of lineNumber.
+func (p *parser) useDevice(m *Map, lineNumber int, d directive.DeviceDirective) {
+	if p.deviceLine.Line != 0 {
+		p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("device already given on %v", p.relative(p.deviceLine))))
+		return
+	}
+	var dev device.Device
+	if d.Path != "" {
+		name := p.resolve(d.Path)
+		var err error
+		dev, err = device.ParseFile(name)
 		if err != nil {
+			p.errs = append(p.errs, p.error(lineNumber, err))
+			return
+		}
+		m.Files = append(m.Files, name)
+	} else {
+		var ok bool
+		dev, ok = device.Builtin(d.Name)
+		if !ok {
+			p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("unknown device %q", d.Name)))
+			return
+		}
+	}
+	p.deviceLine = p.position(lineNumber)
+	p.scope.Pads = dev.Pads
+	p.scope.Controls = dev.Controls
+}
+
+// include parses the file named path, relative to the directory of the file being parsed, into m, as included by the
+// include directive on the line with a number of lineNumber.
+// Each file is only included once, and the layer which the mappings following the include directive belong to is not
+// changed by the layer directives of the included file.
+func (p *parser) include(m *Map, lineNumber int, path string) {
+	name := p.resolve(path)
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("include %q: %v", path, err)))
+		return
+	}
+	for _, f := range p.files {
+		if f == "" {
+			continue
+		}
+		if fabs, err := filepath.Abs(f); err == nil && fabs == abs {
+			p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("include %q: include cycle", path)))
 			return
 		}
-		line = s[:len(s)-1]
-		// skip comments
-		if !strings.HasPrefix(line, "#") {
-			break
+	}
+	if p.included[abs] {
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("include %q: %v", path, err)))
+		return
+	}
+	defer f.Close()
+
+	p.includes = append(p.includes, p.position(lineNumber))
+	layer := p.layer
+	err = p.parseFile(m, f, name)
+	p.layer = layer
+	p.includes = p.includes[:len(p.includes)-1]
+	if err != nil {
+		p.errs = append(p.errs, p.error(lineNumber, fmt.Errorf("include %q: %v", path, err)))
+	}
+}
+
+// addMappings adds the mappings to the layers of m, and the feedback directives to m, except for those which name a
+// layer or a variable m does not have, or which use a variable as if it were of another type, which are reported
+// instead.
+// Layers may be named by mappings before they are declared, which is why this is done after every line is parsed.
+func (p *parser) addMappings(m *Map) {
+	for _, lf := range p.feedback {
+		var err error
+		switch {
+		case lf.feedback.Kind == directive.LayerFeedback && m.LayerIndex(lf.feedback.Name) == -1:
+			err = fmt.Errorf("undeclared layer %q", lf.feedback.Name)
+		case lf.feedback.Kind == directive.VariableFeedback:
+			if _, ok := m.Variable(lf.feedback.Name); !ok {
+				err = fmt.Errorf("undeclared variable %q", lf.feedback.Name)
+			}
+		}
+		if err != nil {
+			lf.err.Err = err
+			p.errs = append(p.errs, lf.err)
+			continue
+		}
+		m.Feedback = append(m.Feedback, lf.feedback)
+	}
+	for _, lm := range p.mappings {
+		if err := checkMapping(*m, lm.mapping); err != nil {
+			lm.err.Err = err
+			p.errs = append(p.errs, lm.err)
+			continue
+		}
+		m.Layers[lm.layer].Mappings = append(m.Layers[lm.layer].Mappings, lm.mapping)
+	}
+	sort.SliceStable(p.errs, func(i, j int) bool {
+		return p.errs[i].order < p.errs[j].order
+	})
+}
+
+// checkMapping returns an error describing the first name in mp which m does not have, or nil if there is none.
+func checkMapping(m Map, mp mapping.Mapping) (err error) {
+	for _, mm := range mp.Matchers() {
+		matcher.Walk(mm, func(n matcher.Matcher) {
+			var operand matcher.Operand
+			switch n := n.(type) {
+			case matcher.MatcherWithoutLogicalOperator:
+				operand = n.LeftOperand
+			case matcher.MatcherWithMembershipOperator:
+				operand = n.LeftOperand
+			}
+			if v, ok := operand.(matcher.Variable); ok && err == nil {
+				if _, ok := m.Variable(v.Name); !ok {
+					err = fmt.Errorf("undeclared variable %q", v.Name)
+				}
+			}
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	for _, a := range mp.Actions {
+		action.Walk(a, func(a action.Action) {
+			if err == nil {
+				err = checkAction(m, a)
+			}
+		})
+	}
+	return
+}
+
+// checkAction returns an error describing the name in a which m does not have, or the variable a uses as if it were of
+// another type, or nil if there is none.
+func checkAction(m Map, a action.Action) error {
+	switch a := a.(type) {
+	case action.LayerAction:
+		if m.LayerIndex(a.Layer) == -1 {
+			return fmt.Errorf("undeclared layer %q", a.Layer)
+		}
+	case action.VariableAction:
+		v, ok := m.Variable(a.Variable)
+		if !ok {
+			return fmt.Errorf("undeclared variable %q", a.Variable)
 		}
+		switch {
+		case a.Operator == action.ToggleVariableOperator && v.Type != directive.BoolType:
+			return fmt.Errorf("cannot toggle integer variable %q", a.Variable)
+		case a.Operator == action.IncrementVariableOperator && v.Type != directive.IntType:
+			return fmt.Errorf("cannot increment or decrement boolean variable %q", a.Variable)
+		case a.Operator == action.SetVariableOperator && v.Type == directive.BoolType && a.Value != 0 && a.Value != 1:
+			return fmt.Errorf("cannot set boolean variable %q to %d", a.Variable, a.Value)
+		}
+	case action.KeyAction:
+		if a.For != nil {
+			return checkExpression(m, a.For)
+		}
+	case action.RepeatAction:
+		return checkExpression(m, a.Count)
+	case action.ScrollAction:
+		return checkExpression(m, a.Amount)
+	case action.AxisAction:
+		return checkExpression(m, a.Value)
 	}
+	return nil
+}
 
-	return mapping.Parse(line)
+// checkExpression returns an error describing the first variable in x which m does not have, or nil if there is none.
+func checkExpression(m Map, x matcher.Expression) (err error) {
+	matcher.WalkExpression(x, func(y matcher.Expression) {
+		if y, ok := y.(matcher.OperandExpression); ok && err == nil {
+			if v, ok := y.Operand.(matcher.Variable); ok {
+				if _, ok := m.Variable(v.Name); !ok {
+					err = fmt.Errorf("undeclared variable %q", v.Name)
+				}
+			}
+		}
+	})
+	return
 }
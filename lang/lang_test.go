@@ -0,0 +1,214 @@
+package lang
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/directive"
+	"github.com/fossegrim/midimap/lang/mapping"
+	"github.com/fossegrim/midimap/lang/matcher"
+)
+
+// Test that Parse parses a simple valid map correctly.
+func TestParse(t *testing.T) {
+	var wantedErr error = nil
+	wantedMap := Map{
+		Evaluation: directive.FirstEvaluation,
+		Layers: []Layer{
+			{
+				Name: BaseLayer,
+				Mappings: []mapping.Mapping{
+					{
+						Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 38},
+						Actions: []action.Action{action.KeyAction{Keycode: 33}},
+					},
+					{
+						Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data2, Operator: matcher.UnequalToOperator, RightOperand: 0},
+						Actions: []action.Action{action.KeyAction{Keycode: 36}},
+						Flow:    mapping.ContinueFlow,
+					},
+				},
+			},
+			{
+				Name: "games",
+				Mappings: []mapping.Mapping{
+					{
+						Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 64},
+						Actions: []action.Action{action.LayerAction{Operator: action.ToggleLayerOperator, Layer: "games"}},
+					},
+				},
+			},
+		},
+	}
+
+	s := "#!/usr/bin/env midimap\nevaluate first\n\ndata1 == 38 -> 33\n# fallback\ndata2 != 0 -> 36; continue\nlayer games\ndata1 == 64 -> layer toggle games"
+	m, err := Parse(strings.NewReader(s))
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !mapsEqual(m, wantedMap) {
+		t.Errorf("Parse(%q) returns an incorrect map %v, want %v.", s, m, wantedMap)
+	}
+}
+
+// Test that Parse reports the lines it cannot parse and keeps the others.
+func TestParseInvalidLines(t *testing.T) {
+	s := "evaluate first\nevaluate all\ndata1 == 38 -> 33\ndata1 38 -> 33\ndata1 == 64 -> layer hold game"
+	wantedErr := `line 2: evaluation mode already given on line 1
+line 4: matcher "data1 38": no valid comparison operator
+line 5: undeclared layer "game"`
+
+	m, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+
+	if len(m.Layers[0].Mappings) != 1 {
+		t.Errorf("Parse(%q) returns %d mappings, want 1.", s, len(m.Layers[0].Mappings))
+	}
+}
+
+// mapsEqual reports whether m and n represent the same map.
+func mapsEqual(m, n Map) bool {
+	if m.Evaluation != n.Evaluation || len(m.Layers) != len(n.Layers) {
+		return false
+	}
+	for i := range m.Layers {
+		if m.Layers[i].Name != n.Layers[i].Name || len(m.Layers[i].Mappings) != len(n.Layers[i].Mappings) {
+			return false
+		}
+		for j := range m.Layers[i].Mappings {
+			if !m.Layers[i].Mappings[j].Equal(n.Layers[i].Mappings[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Test that Parse reports the mappings using variables which are not declared or are of another type.
+func TestParseVariables(t *testing.T) {
+	s := "var mode int\nvar muted bool = true\nmode == 1 -> var toggle muted\nmuted == true -> var inc muted\nlevel == 1 -> 1\nvar mode bool"
+	wantedErr := `line 4: cannot increment or decrement boolean variable "muted"
+line 5: undeclared variable "level"
+line 6: variable "mode" already declared on line 1`
+
+	m, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+
+	if len(m.Variables) != 2 {
+		t.Errorf("Parse(%q) returns %d variables, want 2.", s, len(m.Variables))
+	}
+}
+
+// Test that Parse resolves constants and definitions, and reports those which are undefined or defined twice.
+func TestParseNames(t *testing.T) {
+	s := "let snare = 38\ndef hard = data2 >= 80\ndata1 == snare && hard -> 33\ndata1 == kick -> 33\nlet hard = 1"
+	wantedErr := `line 4: matcher "data1 == kick": undefined name "kick"
+line 5: constant "hard" already declared on line 2`
+	wantedMapping, _ := mapping.Parse("data1 == 38 && data2 >= 80 -> 33")
+
+	m, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+
+	if len(m.Layers[0].Mappings) != 1 || !m.Layers[0].Mappings[0].Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns incorrect mappings %v, want [%v].", s, m.Layers[0].Mappings, wantedMapping)
+	}
+}
+
+// Test that ParseFile parses the files included by a map relative to the including file, and reports the errors of the
+// included files along with the include directives which included them.
+func TestParseFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "pads"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"game.mml":     "include \"pads/td1.mml\"\ndata1 == snare -> 33\ninclude \"pads/td1.mml\"",
+		"pads/td1.mml": "let snare = 38\ninclude \"../game.mml\"\nlayer drums\ndata1 == 99 -> nonsense",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(dir, "game.mml")
+	td1 := filepath.Join(dir, "pads", "td1.mml")
+	wantedErr := td1 + `: line 2: include "../game.mml": include cycle, included from ` + name + `: line 1
+` + td1 + `: line 4: keycode "nonsense": invalid, included from ` + name + ": line 1"
+	wantedMapping, _ := mapping.Parse("data1 == 38 -> 33")
+
+	m, err := ParseFile(name)
+
+	if err == nil {
+		t.Errorf("ParseFile(%q) returns an incorrect error %v, want %q.", name, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("ParseFile(%q) returns an incorrect error %q, want %q.", name, err, wantedErr)
+	}
+
+	if len(m.Layers[0].Mappings) != 1 || !m.Layers[0].Mappings[0].Equal(wantedMapping) {
+		t.Errorf("ParseFile(%q) returns incorrect mappings %v, want [%v].", name, m.Layers[0].Mappings, wantedMapping)
+	}
+
+	if len(m.Files) != 2 || m.Files[0] != name || m.Files[1] != td1 {
+		t.Errorf("ParseFile(%q) returns incorrect files %v, want [%v %v].", name, m.Files, name, td1)
+	}
+}
+
+// Test that Parse resolves the pads of a device profile shipped with midimap.
+func TestParseDevice(t *testing.T) {
+	s := "device td1\npad == snare -> 33\ndevice gm-drums"
+	wantedErr := `line 3: device already given on line 1`
+	wantedMapping, _ := mapping.Parse("pad == 38 -> 33")
+
+	m, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+
+	if len(m.Layers[0].Mappings) != 1 || !m.Layers[0].Mappings[0].Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns incorrect mappings %v, want [%v].", s, m.Layers[0].Mappings, wantedMapping)
+	}
+}
+
+// Test that Parse parses feedback directives, naming a layer before it is declared and an undeclared variable,
+// correctly.
+func TestParseFeedback(t *testing.T) {
+	s := "feedback layer games note 36\nfeedback var muted note 37\nlayer games"
+	wantedErr := `line 2: undeclared variable "muted"`
+	wantedFeedback, _ := directive.Parse("feedback layer games note 36")
+
+	m, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+
+	if len(m.Feedback) != 1 || !m.Feedback[0].Equal(wantedFeedback) {
+		t.Errorf("Parse(%q) returns incorrect feedback %v, want [%v].", s, m.Feedback, wantedFeedback)
+	}
+}
@@ -4,36 +4,198 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/fossegrim/midimap/lang/action"
 	"github.com/fossegrim/midimap/lang/helper"
-	"github.com/fossegrim/midimap/lang/keycode"
 	"github.com/fossegrim/midimap/lang/matcher"
 )
 
 type Mapping struct {
 	Matcher matcher.Matcher
-	Keycode int
+	// Sequence are the matchers which must match, in order, the messages preceding the message Matcher matches, or nil
+	// if the mapping is not a sequence.
+	Sequence []matcher.Matcher
+	// Within is how long a sequence may take, from the first message of Sequence until the message Matcher matches.
+	Within time.Duration
+	// Actions are the actions of the mapping, which are performed in order.
+	Actions []action.Action
+	Flow    Flow
+	// Hold is how long the keys pressed by the actions are held down, or 0 if it is up to the map.
+	Hold time.Duration
+	// Retrigger decides what happens when the mapping matches while its actions are still performed, as they are when
+	// they wait.
+	Retrigger Retrigger
 }
 
 func (m Mapping) Equal(n Mapping) bool {
-	return m.Matcher.Equal(n.Matcher) && m.Keycode == n.Keycode
+	if len(m.Sequence) != len(n.Sequence) {
+		return false
+	}
+	for i := range m.Sequence {
+		if !m.Sequence[i].Equal(n.Sequence[i]) {
+			return false
+		}
+	}
+	if len(m.Actions) != len(n.Actions) {
+		return false
+	}
+	for i := range m.Actions {
+		if !m.Actions[i].Equal(n.Actions[i]) {
+			return false
+		}
+	}
+	return m.Matcher.Equal(n.Matcher) && m.Within == n.Within && m.Flow == n.Flow && m.Hold == n.Hold &&
+		m.Retrigger == n.Retrigger
+}
+
+// Waits reports whether any of the actions of m is a wait, in which case the actions are performed in the background.
+func (m Mapping) Waits() bool {
+	for _, a := range m.Actions {
+		if _, ok := a.(action.WaitAction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matchers returns every matcher of m, that is the matchers of its sequence followed by its matcher.
+func (m Mapping) Matchers() []matcher.Matcher {
+	return append(append([]matcher.Matcher(nil), m.Sequence...), m.Matcher)
 }
 
-// Parse parses a mapping as specified in Section 1.2 MAPPINGS of the midimap-lang specification.
+// Flow decides whether the mappings following a matching mapping are evaluated.
+type Flow int
+
+const (
+	// DefaultFlow leaves the decision to the evaluation mode of the map.
+	DefaultFlow Flow = iota
+	// StopFlow stops evaluation after the mapping matches.
+	StopFlow
+	// ContinueFlow continues evaluation after the mapping matches.
+	ContinueFlow
+)
+
+// Retrigger decides what happens when a mapping matches while its actions are still performed.
+type Retrigger int
+
+const (
+	// QueueRetrigger performs the actions once more after they are done.
+	QueueRetrigger Retrigger = iota
+	// RestartRetrigger stops performing the actions, and starts over.
+	RestartRetrigger
+	// IgnoreRetrigger ignores the match.
+	IgnoreRetrigger
+)
+
+// retriggers are the retrigger options by name.
+var retriggers = map[string]Retrigger{
+	"queue":   QueueRetrigger,
+	"restart": RestartRetrigger,
+	"ignore":  IgnoreRetrigger,
+}
+
+// Parse parses a mapping as specified in Section 1.2 MAPPINGS of the midimap-lang specification, in the default scope.
 //
 // If s is a valid mapping as described by the specification, Parse returns mapping, nil.
 // Otherwise, Parse returns an error describing why the mapping is invalid.
-func Parse(s string) (mapping Mapping, err error) {
+func Parse(s string) (Mapping, error) {
+	return ParseInScope(s, matcher.DefaultScope)
+}
+
+// ParseInScope is like Parse, but parses the matchers and expressions of s in scope rather than in the default scope.
+func ParseInScope(s string, scope matcher.Scope) (mapping Mapping, err error) {
 	r := regexp.MustCompilePOSIX("->")
 	before, after, ok := helper.BeforeAndAfter(r, s)
 	if !ok {
 		err = fmt.Errorf("mapping %q: no valid separator", s)
 		return
 	}
-	mapping.Matcher, err = matcher.Parse(strings.TrimSpace(before))
+	err = parseMatchers(s, strings.TrimSpace(before), scope, &mapping)
 	if err != nil {
 		return
 	}
-	mapping.Keycode, err = keycode.Parse(strings.TrimSpace(after))
+
+	// The options, if any, are separated from the actions by a semicolon.
+	if actions, options, ok := helper.BeforeAndAfterOutsideBrackets(semicolonRegexp, after); ok {
+		err = parseOptions(s, options, &mapping)
+		if err != nil {
+			return
+		}
+		after = actions
+	}
+	mapping.Actions, err = action.ParseListInScope(strings.TrimSpace(after), scope)
 	return
 }
+
+var semicolonRegexp = regexp.MustCompile(";")
+
+// parseMatchers parses the matchers of the mapping s, that is the matcher or sequence of matchers to the left of its
+// separator, into mapping.
+// A sequence is written as matchers separated by then followed by within and a duration, such as:
+// data1 == 36 then data1 == 36 then data1 == 38 within 600ms
+func parseMatchers(s, matchers string, scope matcher.Scope, mapping *Mapping) (err error) {
+	steps := regexp.MustCompilePOSIX(" then ").Split(matchers, -1)
+	if len(steps) == 1 {
+		mapping.Matcher, err = scope.Parse(matchers)
+		return
+	}
+
+	last := steps[len(steps)-1]
+	i := strings.LastIndex(last, " within ")
+	if i == -1 {
+		return fmt.Errorf("mapping %q: sequence without within", s)
+	}
+	mapping.Within, err = matcher.ParseDuration(strings.TrimSpace(last[i+len(" within "):]))
+	if err != nil || mapping.Within == 0 {
+		return fmt.Errorf("mapping %q: no valid sequence duration", s)
+	}
+	steps[len(steps)-1] = last[:i]
+
+	for _, step := range steps[:len(steps)-1] {
+		var m matcher.Matcher
+		m, err = scope.Parse(strings.TrimSpace(step))
+		if err != nil {
+			return
+		}
+		mapping.Sequence = append(mapping.Sequence, m)
+	}
+	mapping.Matcher, err = scope.Parse(strings.TrimSpace(steps[len(steps)-1]))
+	return
+}
+
+// parseOptions parses the space separated options of the mapping s into mapping.
+func parseOptions(s, options string, mapping *Mapping) (err error) {
+	retriggered := false
+	fields := strings.Fields(options)
+	for i := 0; i < len(fields); i++ {
+		switch option := fields[i]; option {
+		case "stop", "continue":
+			if mapping.Flow != DefaultFlow {
+				return fmt.Errorf("mapping %q: more than one of stop and continue", s)
+			}
+			mapping.Flow = StopFlow
+			if option == "continue" {
+				mapping.Flow = ContinueFlow
+			}
+		case "queue", "restart", "ignore":
+			if retriggered {
+				return fmt.Errorf("mapping %q: more than one of queue, restart and ignore", s)
+			}
+			retriggered = true
+			mapping.Retrigger = retriggers[option]
+		case "hold":
+			if mapping.Hold != 0 || i+1 == len(fields) {
+				return fmt.Errorf("mapping %q: hold takes exactly one duration", s)
+			}
+			i++
+			mapping.Hold, err = matcher.ParseDuration(fields[i])
+			if err != nil || mapping.Hold == 0 {
+				return fmt.Errorf("mapping %q: no valid hold duration", s)
+			}
+		default:
+			return fmt.Errorf("mapping %q: invalid option %q", s, option)
+		}
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package mapping
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/fossegrim/midimap/lang/action"
 	"github.com/fossegrim/midimap/lang/matcher"
 )
 
@@ -16,7 +18,7 @@ func TestParse(t *testing.T) {
 			matcher.LogicalAndOperator,
 			matcher.MatcherWithoutLogicalOperator{matcher.Data2, matcher.EqualToOperator, 64},
 		},
-		Keycode: 1,
+		Actions: []action.Action{action.KeyAction{Keycode: 1}},
 	}
 
 	s := "data1 == 44 && data2 == 64 -> 1"
@@ -44,3 +46,122 @@ func TestParseInvalidSeparator(t *testing.T) {
 		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
 	}
 }
+
+// Test that Parse parses a mapping, with a stop option, correctly.
+func TestParseStop(t *testing.T) {
+	var wantedErr error = nil
+	wantedMapping := Mapping{
+		Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 38},
+		Actions: []action.Action{action.KeyAction{Keycode: 33}},
+		Flow:    StopFlow,
+	}
+
+	s := "data1 == 38 -> 33; stop"
+	mapping, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !mapping.Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns an incorrect mapping %v, want %v.", s, mapping, wantedMapping)
+	}
+}
+
+// Test that Parse parses a mapping, with both a stop and a continue option, correctly.
+func TestParseStopAndContinue(t *testing.T) {
+	s := "data1 == 38 -> 33; stop continue"
+	wantedErr := fmt.Errorf("mapping %q: more than one of stop and continue", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a mapping, with a layer action, correctly.
+func TestParseLayerAction(t *testing.T) {
+	var wantedErr error = nil
+	wantedMapping := Mapping{
+		Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 64},
+		Actions: []action.Action{action.LayerAction{Operator: action.HoldLayerOperator, Layer: "games"}},
+	}
+
+	s := "data1 == 64 -> layer hold games"
+	mapping, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !mapping.Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns an incorrect mapping %v, want %v.", s, mapping, wantedMapping)
+	}
+}
+
+// Test that Parse parses a mapping, with a sequence of matchers, correctly.
+func TestParseSequence(t *testing.T) {
+	var wantedErr error = nil
+	kick := matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 36}
+	wantedMapping := Mapping{
+		Matcher:  matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 38},
+		Sequence: []matcher.Matcher{kick, kick},
+		Within:   600 * time.Millisecond,
+		Actions:  []action.Action{action.KeyAction{Keycode: 33}},
+	}
+
+	s := "data1 == 36 then data1 == 36 then data1 == 38 within 600ms -> 33"
+	mapping, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !mapping.Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns an incorrect mapping %v, want %v.", s, mapping, wantedMapping)
+	}
+}
+
+// Test that Parse parses a mapping, with a sequence of matchers lacking a duration, correctly.
+func TestParseSequenceNoWithin(t *testing.T) {
+	s := "data1 == 36 then data1 == 38 -> 33"
+	wantedErr := fmt.Errorf("mapping %q: sequence without within", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a mapping, with several actions and a retrigger option, correctly.
+func TestParseActionsRestart(t *testing.T) {
+	var wantedErr error = nil
+	wantedMapping := Mapping{
+		Matcher: matcher.MatcherWithoutLogicalOperator{LeftOperand: matcher.Data1, Operator: matcher.EqualToOperator, RightOperand: 38},
+		Actions: []action.Action{
+			action.KeyAction{Keycode: 45, Modifiers: action.CtrlModifier},
+			action.WaitAction{Duration: 30 * time.Millisecond},
+			action.ExecAction{Command: "notify-send 'saved; maybe'"},
+		},
+		Flow:      ContinueFlow,
+		Hold:      16 * time.Millisecond,
+		Retrigger: RestartRetrigger,
+	}
+
+	s := `data1 == 38 -> ctrl+x, wait 30ms, exec "notify-send 'saved; maybe'"; restart hold 16ms continue`
+	mapping, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !mapping.Equal(wantedMapping) {
+		t.Errorf("Parse(%q) returns an incorrect mapping %v, want %v.", s, mapping, wantedMapping)
+	}
+}
@@ -0,0 +1,233 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseExpression parses an arithmetic expression, such as data2 * 2 or (data2 - 10) / 32, in the default scope.
+// Its operands are integers, literals, durations, which are in milliseconds, and the left operands of matchers,
+// combined with +, -, *, / and %, which have the usual precedence, and parentheses.
+//
+// If s is a valid expression, ParseExpression returns expression, nil.
+// Otherwise, ParseExpression returns an error describing why the expression is invalid.
+func ParseExpression(s string) (Expression, error) {
+	return DefaultScope.ParseExpression(s)
+}
+
+// ParseExpression is like the ParseExpression function of this package, but parses s in scope rather than in the
+// default scope.
+func (scope Scope) ParseExpression(s string) (Expression, error) {
+	p := expressionParser{scope: scope, s: s, tokens: tokenizeExpression(s)}
+	x, err := p.parseSum()
+	if err == nil && len(p.tokens) > 0 {
+		err = fmt.Errorf("expression %q: unexpected %q", s, p.tokens[0])
+	}
+	return x, err
+}
+
+// tokenizeExpression splits s into operators, parentheses and operands.
+func tokenizeExpression(s string) (tokens []string) {
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		n := 1
+		switch {
+		case strings.HasPrefix(s, "prev(data2)"):
+			n = len("prev(data2)")
+		case strings.ContainsAny(s[:1], "+-*/%()"):
+		default:
+			n = strings.IndexAny(s, "+-*/%() ")
+			if n == -1 {
+				n = len(s)
+			}
+		}
+		tokens = append(tokens, s[:n])
+		s = s[n:]
+	}
+	return
+}
+
+// expressionParser is a recursive descent parser of the expression s, whose tokens are yet to be parsed.
+type expressionParser struct {
+	scope  Scope
+	s      string
+	tokens []string
+}
+
+func (p *expressionParser) next() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+// parseSum parses terms separated by + and -.
+func (p *expressionParser) parseSum() (Expression, error) {
+	return p.parseBinary(p.parseTerm, map[string]ArithmeticOperator{"+": AddOperator, "-": SubtractOperator})
+}
+
+// parseTerm parses factors separated by *, / and %.
+func (p *expressionParser) parseTerm() (Expression, error) {
+	return p.parseBinary(p.parseFactor, map[string]ArithmeticOperator{"*": MultiplyOperator, "/": DivideOperator, "%": RemainderOperator})
+}
+
+// parseBinary parses operands parsed by parse separated by operators, which are left-associative.
+func (p *expressionParser) parseBinary(parse func() (Expression, error), operators map[string]ArithmeticOperator) (Expression, error) {
+	x, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := operators[p.next()]
+		if !ok {
+			return x, nil
+		}
+		p.tokens = p.tokens[1:]
+		y, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		x = BinaryExpression{x, operator, y}
+	}
+}
+
+// parseFactor parses an operand, a negated factor or a parenthesized expression.
+func (p *expressionParser) parseFactor() (Expression, error) {
+	token := p.next()
+	if token == "" {
+		return nil, fmt.Errorf("expression %q: unexpected end", p.s)
+	}
+	p.tokens = p.tokens[1:]
+	switch token {
+	case "-":
+		x, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return BinaryExpression{Constant{0}, SubtractOperator, x}, nil
+	case "(":
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("expression %q: unterminated parenthesis", p.s)
+		}
+		p.tokens = p.tokens[1:]
+		return x, nil
+	}
+
+	if IsIdentifier(token) && IsReserved(token) && token != "true" && token != "false" || token == "prev(data2)" {
+		return OperandExpression{parseLeftOperand(token)}, nil
+	}
+	if IsIdentifier(token) && !p.scope.IsDefined(token) && !IsNoteName(token) {
+		// Names which are not constants are variables, just like the left operands of matchers.
+		return OperandExpression{Variable{token}}, nil
+	}
+	if d, err := ParseDuration(token); err == nil && strings.IndexAny(token, "0123456789") == 0 && !isDigits(token) {
+		return Constant{d.Milliseconds()}, nil
+	}
+	n, err := p.scope.parseLiteral(nil, token)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: no valid operand %q", p.s, token)
+	}
+	return Constant{n}, nil
+}
+
+// isDigits reports whether s consists of digits only.
+func isDigits(s string) bool {
+	return strings.Trim(s, "0123456789") == ""
+}
+
+// Expression is a discriminated union of Constant, OperandExpression and BinaryExpression.
+//
+// See the Matcher type for an explanation of how discriminated unions are represented.
+type Expression interface {
+	isExpression()
+	Equal(Expression) bool
+}
+
+// Constant is an expression whose value is Value.
+type Constant struct {
+	Value int64
+}
+
+// Equal reports whether x and y represent the same expression.
+func (x Constant) Equal(y Expression) bool {
+	yy, ok := y.(Constant)
+	return ok && x == yy
+}
+
+func (_ Constant) isExpression() {}
+
+// OperandExpression is an expression whose value is the value of Operand, just like in a matcher.
+type OperandExpression struct {
+	Operand Operand
+}
+
+// Equal reports whether x and y represent the same expression.
+func (x OperandExpression) Equal(y Expression) bool {
+	yy, ok := y.(OperandExpression)
+	return ok && x.Operand.Equal(yy.Operand)
+}
+
+func (_ OperandExpression) isExpression() {}
+
+// BinaryExpression is an expression whose value is the result of Operator applied to the values of Left and Right.
+type BinaryExpression struct {
+	Left     Expression
+	Operator ArithmeticOperator
+	Right    Expression
+}
+
+// Equal reports whether x and y represent the same expression.
+func (x BinaryExpression) Equal(y Expression) bool {
+	yy, ok := y.(BinaryExpression)
+	return ok && x.Left.Equal(yy.Left) && x.Operator == yy.Operator && x.Right.Equal(yy.Right)
+}
+
+func (_ BinaryExpression) isExpression() {}
+
+type ArithmeticOperator int
+
+const (
+	AddOperator ArithmeticOperator = iota
+	SubtractOperator
+	MultiplyOperator
+	// DivideOperator truncates towards zero.
+	DivideOperator
+	RemainderOperator
+)
+
+// Apply returns the result of o applied to x and y, and false if it has none, as when dividing by zero.
+func (o ArithmeticOperator) Apply(x, y int64) (int64, bool) {
+	switch o {
+	case AddOperator:
+		return x + y, true
+	case SubtractOperator:
+		return x - y, true
+	case MultiplyOperator:
+		return x * y, true
+	case DivideOperator:
+		return x / nonZero(y), y != 0
+	case RemainderOperator:
+		return x % nonZero(y), y != 0
+	default:
+		panic("unreachable")
+	}
+}
+
+func nonZero(n int64) int64 {
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// WalkExpression calls f for x and for every expression which x is composed of, in depth-first order.
+func WalkExpression(x Expression, f func(Expression)) {
+	f(x)
+	if x, ok := x.(BinaryExpression); ok {
+		WalkExpression(x.Left, f)
+		WalkExpression(x.Right, f)
+	}
+}
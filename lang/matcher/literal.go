@@ -0,0 +1,127 @@
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseInteger parses an integer as specified in Section 1.2.1.1 INTEGERS of the midimap-lang specification.
+// Besides base-10 integers, the booleans true and false are integers with the values 1 and 0 respectively.
+//
+// If s is a valid integer as described by the specification, ParseInteger returns integer, nil.
+// Otherwise, ParseInteger returns an error describing why the integer is invalid.
+func ParseInteger(s string) (int64, error) {
+	switch s {
+	case "true":
+		return 1, nil
+	case "false":
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("integer %q: invalid", s)
+	}
+	return n, nil
+}
+
+// parseLiteral parses the value which left is compared to, which is either an integer, a constant of scope, a pad or
+// control of scope if left is a pad or control operand respectively, or a literal as specified in Section 1.2.1.3 LITERALS of the midimap-lang specification, such as C#2, 0x26 or 0b100110.
+//
+// Unlike integers, which may be compared to any value, note names and hexadecimal and binary literals must be in the
+// range of values of left, if left has such a range.
+func (scope Scope) parseLiteral(left Operand, s string) (n int64, err error) {
+	if n, ok := scope.Constants[s]; ok {
+		return n, nil
+	}
+	switch left {
+	case Pad:
+		if n, ok := scope.Pads[s]; ok {
+			return n, nil
+		}
+	case Control:
+		if n, ok := scope.Controls[s]; ok {
+			return n, nil
+		}
+	}
+	switch {
+	case noteNameRegexp.MatchString(s):
+		n, err = scope.parseNoteName(s)
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		n, err = strconv.ParseInt(s[2:], 16, 64)
+	case strings.HasPrefix(s, "0b"), strings.HasPrefix(s, "0B"):
+		n, err = strconv.ParseInt(s[2:], 2, 64)
+	case IsIdentifier(s) && !IsReserved(s):
+		return 0, fmt.Errorf("undefined name %q", s)
+	default:
+		return ParseInteger(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("literal %q: invalid", s)
+	}
+	if max, ok := maxValue(left); ok && (n < 0 || n > max) {
+		return 0, fmt.Errorf("literal %q: out of range 0 to %d", s, max)
+	}
+	return n, nil
+}
+
+// maxValue returns the greatest value o can have, if o has a range of values at all.
+// The least value is always 0.
+func maxValue(o Operand) (int64, bool) {
+	switch o {
+	case Data1, Data2, Pad, Control, PreviousData2:
+		return 127, true
+	case Status:
+		return 255, true
+	default:
+		return 0, false
+	}
+}
+
+var noteNameRegexp = regexp.MustCompile(`^[A-Ga-g][#b]?-?[0-9]+$`)
+
+// IsNoteName reports whether s has the form of a note name with an octave, such as C#2, and therefore cannot be a name.
+func IsNoteName(s string) bool {
+	return noteNameRegexp.MatchString(s)
+}
+
+// semitones are the number of semitones from C to each natural note within an octave.
+var semitones = map[byte]int64{'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11}
+
+// parseNoteName parses a note name with an octave, such as C#2 or Eb-1, into the number of the note.
+func (scope Scope) parseNoteName(s string) (int64, error) {
+	n := semitones[strings.ToLower(s)[0]]
+	s = s[1:]
+	switch {
+	case strings.HasPrefix(s, "#"):
+		n++
+		s = s[1:]
+	case strings.HasPrefix(s, "b"):
+		n--
+		s = s[1:]
+	}
+	octave, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n += 60 + (octave-scope.MiddleCOctave)*12
+	if n < 0 || n > 127 {
+		return 0, fmt.Errorf("note out of range")
+	}
+	return n, nil
+}
+
+// ParseDuration parses a duration as specified in Section 1.2.1.2 DURATIONS of the midimap-lang specification, such
+// as 40ms or 1s.
+//
+// If s is a valid duration as described by the specification, ParseDuration returns duration, nil.
+// Otherwise, ParseDuration returns an error describing why the duration is invalid.
+func ParseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 || strings.IndexAny(s, "0123456789") != 0 {
+		return 0, fmt.Errorf("duration %q: invalid", s)
+	}
+	return d, nil
+}
@@ -3,31 +3,37 @@ package matcher
 import (
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fossegrim/midimap/lang/helper"
 )
 
-// Parse parses a matcher as specified in Section 1.2.1 MATCHERS of the midimap-lang specification.
+// Parse parses a matcher as specified in Section 1.2.1 MATCHERS of the midimap-lang specification, in the default
+// scope.
 //
 // If s is a valid matcher as described by the specification, Parse returns matcher, nil.
 // Otherwise, Parse returns an error describing why the matcher is invalid.
 // s may not contain any leading or trailing space characters.
 func Parse(s string) (Matcher, error) {
+	return DefaultScope.Parse(s)
+}
+
+// Parse is like the Parse function of this package, but parses s in scope rather than in the default scope.
+func (scope Scope) Parse(s string) (Matcher, error) {
 	left, right, operator := beforeAndAfterLogicalOperator(s)
 	if operator == NoLogicalOperator {
-		return parseMatcherWithoutLogicalOperator(s)
+		return scope.parseMatcherWithoutLogicalOperator(s)
 	}
 
 	var matcher MatcherWithLogicalOperator
 	matcher.Operator = operator
 	var err error
-	matcher.LeftMatcher, err = Parse(strings.TrimSpace(left))
+	matcher.LeftMatcher, err = scope.Parse(strings.TrimSpace(left))
 	if err != nil {
 		return matcher, err
 	}
-	matcher.RightMatcher, err = Parse(strings.TrimSpace(right))
+	matcher.RightMatcher, err = scope.Parse(strings.TrimSpace(right))
 	return matcher, err
 }
 
@@ -36,58 +42,377 @@ func Parse(s string) (Matcher, error) {
 // If s is a valid matcher, parseMatcherWithoutLogicalOperator returns matcher, nil.
 // Otherwise, parseMatcherWithoutLogicalOperator returns an error describing why the matcher without
 // s may not contain any leading or trailing space characters or any logical operators, that is && or ||.
-func parseMatcherWithoutLogicalOperator(s string) (m MatcherWithoutLogicalOperator, err error) {
-	unParsed := s // the characters of s which are yet to be parsed
+func (scope Scope) parseMatcherWithoutLogicalOperator(s string) (Matcher, error) {
+	switch {
+	case strings.HasPrefix(s, "chord("):
+		return scope.parseChordMatcher(s)
+	case strings.HasPrefix(s, "taps("):
+		return parseTapsMatcher(s)
+	case strings.HasPrefix(s, "crosses "):
+		return scope.parseCrossingMatcher(s)
+	case strings.HasPrefix(s, "rate("):
+		return scope.parseRateMatcher(s)
+	}
 
+	name := leftOperandPrefix(s)
+	if name == s && IsIdentifier(name) && !IsReserved(name) {
+		if m, ok := scope.Definitions[name]; ok {
+			return m, nil
+		}
+		return nil, fmt.Errorf("matcher %q: undefined name %q", s, name)
+	}
+	rest := strings.TrimLeft(s[len(name):], " ")
 	switch {
-	case strings.HasPrefix(unParsed, "data1"):
-		m.LeftOperand = Data1
-	case strings.HasPrefix(unParsed, "data2"):
-		m.LeftOperand = Data2
+	case name != "" && strings.HasPrefix(rest, "in "):
+		return scope.parseMembership(s, parseLeftOperand(name), InOperator, rest[len("in "):])
+	case name != "" && strings.HasPrefix(rest, "not in "):
+		return scope.parseMembership(s, parseLeftOperand(name), NotInOperator, rest[len("not in "):])
+	}
+	return scope.parseComparison(s)
+}
+
+// leftOperandPrefix returns the name of the left operand which s starts with, which is either an identifier or
+// prev(data2), or "" if s does not start with a left operand.
+func leftOperandPrefix(s string) string {
+	if strings.HasPrefix(s, "prev(data2)") {
+		return "prev(data2)"
+	}
+	return identifierPrefix(s)
+}
+
+// parseLeftOperand parses the left operand named name.
+func parseLeftOperand(name string) Operand {
+	switch name {
+	case "data1":
+		return Data1
+	case "data2":
+		return Data2
+	case "status":
+		return Status
+	case "pad":
+		return Pad
+	case "control":
+		return Control
+	case "held":
+		return Held
+	case "holding":
+		return Holding
+	case "prev(data2)":
+		return PreviousData2
+	case "delta":
+		return Delta
+	case "direction":
+		return Direction
+	case "steps":
+		return Steps
 	default:
+		return Variable{name}
+	}
+}
+
+// parseComparison parses a matcher without a logical operator which compares two operands.
+func (scope Scope) parseComparison(s string) (m MatcherWithoutLogicalOperator, err error) {
+	unParsed := s // the characters of s which are yet to be parsed
+
+	name := leftOperandPrefix(unParsed)
+	if name == "" {
 		err = fmt.Errorf("matcher %q: no valid left operand", s)
 		return
 	}
-	unParsed = unParsed[len("datax"):] // Discard parsed leftOperand
+	m.LeftOperand = parseLeftOperand(name)
+	unParsed = unParsed[len(name):] // Discard parsed leftOperand
 
 	skipToNonSpaceCharacter(&unParsed)
 	var operatorLength int
-	switch {
-	case strings.HasPrefix(unParsed, "=="):
-		m.Operator = EqualToOperator
-		operatorLength = 2
-	case strings.HasPrefix(unParsed, "!="):
-		m.Operator = UnequalToOperator
-		operatorLength = 2
-	case strings.HasPrefix(unParsed, "<="):
-		m.Operator = LessThanOrEqualToOperator
-		operatorLength = 2
-	case strings.HasPrefix(unParsed, ">="):
-		m.Operator = GreaterThanOrEqualToOperator
-		operatorLength = 2
-	case strings.HasPrefix(unParsed, "<"):
-		m.Operator = LessThanOperator
-		operatorLength = 1
-	case strings.HasPrefix(unParsed, ">"):
-		m.Operator = GreaterThanOperator
-		operatorLength = 1
-	default:
+	m.Operator, operatorLength = comparisonOperatorPrefix(unParsed)
+	if operatorLength == 0 {
 		err = fmt.Errorf("matcher %q: no valid comparison operator", s)
 		return
 	}
 	unParsed = unParsed[operatorLength:] // Discard parsed operator
+	if m.LeftOperand == Holding {
+		switch m.Operator {
+		case EqualToOperator, GreaterThanOrEqualToOperator, GreaterThanOperator:
+		default:
+			// A note which is still held is evaluated once it has been held for a duration, which the other comparisons
+			// have none of.
+			err = fmt.Errorf("matcher %q: holding can only be compared with ==, >= or >", s)
+			return
+		}
+	}
 
 	skipToNonSpaceCharacter(&unParsed)
-	n, err := strconv.ParseInt(unParsed, 10, 64)
+	m.RightOperand, err = scope.parseRightOperand(m.LeftOperand, unParsed)
 	if err != nil {
-		err = fmt.Errorf("matcher %q: no valid right operand", s)
+		err = fmt.Errorf("matcher %q: no valid right operand: %w", s, err)
+		if IsIdentifier(unParsed) && !IsReserved(unParsed) && !IsNoteName(unParsed) {
+			err = fmt.Errorf("matcher %q: undefined name %q", s, unParsed)
+		}
+	}
+	return
+}
+
+// comparisonOperatorPrefix returns the comparison operator which s starts with along with its length, or a length of 0
+// if s does not start with a comparison operator.
+func comparisonOperatorPrefix(s string) (ComparisonOperator, int) {
+	switch {
+	case strings.HasPrefix(s, "=="):
+		return EqualToOperator, 2
+	case strings.HasPrefix(s, "!="):
+		return UnequalToOperator, 2
+	case strings.HasPrefix(s, "<="):
+		return LessThanOrEqualToOperator, 2
+	case strings.HasPrefix(s, ">="):
+		return GreaterThanOrEqualToOperator, 2
+	case strings.HasPrefix(s, "<"):
+		return LessThanOperator, 1
+	case strings.HasPrefix(s, ">"):
+		return GreaterThanOperator, 1
+	default:
+		return 0, 0
+	}
+}
+
+// parseRightOperand parses the value which left is compared to.
+// Duration operands are compared to durations, in milliseconds, and the other operands to integers.
+func (scope Scope) parseRightOperand(left Operand, s string) (int64, error) {
+	if _, ok := left.(Duration); ok {
+		d, err := ParseDuration(s)
+		return d.Milliseconds(), err
+	}
+	return scope.parseLiteral(left, s)
+}
+
+// parseMembership parses the set of a matcher s with a membership operator, whose left operand and operator are already
+// parsed, such as 36..48 or {37, 38, 40}.
+func (scope Scope) parseMembership(s string, left Operand, operator MembershipOperator, set string) (m MatcherWithMembershipOperator, err error) {
+	if left == Holding {
+		err = fmt.Errorf("matcher %q: holding can only be compared with ==, >= or >", s)
 		return
 	}
-	m.RightOperand = int64(n)
+	m.LeftOperand = left
+	m.Operator = operator
+	set = strings.TrimSpace(set)
+	elements := []string{set}
+	if strings.HasPrefix(set, "{") && strings.HasSuffix(set, "}") {
+		elements = strings.Split(set[1:len(set)-1], ",")
+	}
+	for _, element := range elements {
+		var r Range
+		r, err = scope.parseRange(left, strings.TrimSpace(element))
+		if err != nil {
+			err = fmt.Errorf("matcher %q: no valid set element %q", s, strings.TrimSpace(element))
+			return
+		}
+		m.Set = append(m.Set, r)
+	}
+	return
+}
 
+// parseRange parses an element of a set, which is either a single value or an inclusive range of values such as 36..48.
+func (scope Scope) parseRange(left Operand, s string) (r Range, err error) {
+	low, high := s, s
+	if i := strings.Index(s, ".."); i != -1 {
+		low, high = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(".."):])
+	}
+	r.Low, err = scope.parseRightOperand(left, low)
+	if err != nil {
+		return
+	}
+	r.High, err = scope.parseRightOperand(left, high)
+	if err != nil {
+		return
+	}
+	if r.Low > r.High {
+		err = fmt.Errorf("range %q: empty", s)
+	}
 	return
 }
 
+// IsReserved reports whether name is an identifier with a meaning of its own in matchers, which therefore cannot name
+// a variable.
+func IsReserved(name string) bool {
+	switch name {
+	case "data1", "data2", "status", "pad", "control", "held", "holding", "prev", "delta", "direction", "steps", "crosses", "rate", "true", "false", "in", "not":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseChordMatcher parses a chord matcher, such as chord(36, 40, 43) within 40ms.
+func (scope Scope) parseChordMatcher(s string) (m ChordMatcher, err error) {
+	end := strings.Index(s, ")")
+	if end == -1 {
+		err = fmt.Errorf("matcher %q: unterminated chord", s)
+		return
+	}
+	for _, note := range strings.Split(s[len("chord("):end], ",") {
+		var n int64
+		n, err = scope.parseLiteral(Pad, strings.TrimSpace(note))
+		if err != nil || n < 0 || n > 127 {
+			err = fmt.Errorf("matcher %q: no valid chord note %q", s, strings.TrimSpace(note))
+			return
+		}
+		m.Notes = append(m.Notes, n)
+	}
+	if len(m.Notes) < 2 {
+		err = fmt.Errorf("matcher %q: a chord must have at least two notes", s)
+		return
+	}
+
+	options := strings.Fields(s[end+1:])
+	for len(options) > 0 {
+		switch {
+		case options[0] == "within" && len(options) > 1:
+			m.Within, err = ParseDuration(options[1])
+			if err != nil {
+				err = fmt.Errorf("matcher %q: no valid chord duration", s)
+				return
+			}
+			options = options[2:]
+		case options[0] == "exclusive":
+			m.Exclusive = true
+			options = options[1:]
+		default:
+			err = fmt.Errorf("matcher %q: invalid chord option %q", s, options[0])
+			return
+		}
+	}
+	if m.Exclusive && m.Within == 0 {
+		err = fmt.Errorf("matcher %q: an exclusive chord must have a duration", s)
+	}
+	return
+}
+
+// parseTapsMatcher parses a taps matcher, such as taps(2) within 250ms.
+func parseTapsMatcher(s string) (m TapsMatcher, err error) {
+	end := strings.Index(s, ")")
+	if end == -1 {
+		err = fmt.Errorf("matcher %q: unterminated taps", s)
+		return
+	}
+	m.Count, err = ParseInteger(strings.TrimSpace(s[len("taps("):end]))
+	if err != nil || m.Count < 1 {
+		err = fmt.Errorf("matcher %q: no valid tap count", s)
+		return
+	}
+
+	options := strings.Fields(s[end+1:])
+	if len(options) < 2 || options[0] != "within" {
+		err = fmt.Errorf("matcher %q: taps without within", s)
+		return
+	}
+	m.Within, err = ParseDuration(options[1])
+	if err != nil || m.Within == 0 {
+		err = fmt.Errorf("matcher %q: no valid taps duration", s)
+		return
+	}
+	switch {
+	case len(options) == 3 && options[2] == "immediate":
+		m.Immediate = true
+	case len(options) != 2:
+		err = fmt.Errorf("matcher %q: invalid taps option %q", s, options[2])
+	}
+	return
+}
+
+// parseCrossingMatcher parses a crossing matcher, such as crosses up 100 rearm 90.
+func (scope Scope) parseCrossingMatcher(s string) (m CrossingMatcher, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 && (len(fields) != 5 || fields[3] != "rearm") {
+		err = fmt.Errorf("matcher %q: crosses takes up or down, a threshold and optionally rearm and a level", s)
+		return
+	}
+	switch fields[1] {
+	case "up":
+		m.Direction = UpDirection
+	case "down":
+		m.Direction = DownDirection
+	default:
+		err = fmt.Errorf("matcher %q: no valid crossing direction", s)
+		return
+	}
+	m.Threshold, err = scope.parseLiteral(Data2, fields[2])
+	if err != nil {
+		err = fmt.Errorf("matcher %q: no valid crossing threshold", s)
+		return
+	}
+	if len(fields) == 3 {
+		m.Rearm = m.Threshold - int64(m.Direction)
+		return
+	}
+	m.Rearm, err = scope.parseLiteral(Data2, fields[4])
+	if err != nil {
+		err = fmt.Errorf("matcher %q: no valid rearm level", s)
+		return
+	}
+	if m.Direction == UpDirection && m.Rearm >= m.Threshold || m.Direction == DownDirection && m.Rearm <= m.Threshold {
+		err = fmt.Errorf("matcher %q: the rearm level must be on the other side of the threshold", s)
+	}
+	return
+}
+
+// parseRateMatcher parses a rate matcher, such as rate(data1 == 38) >= 8/s.
+func (scope Scope) parseRateMatcher(s string) (m RateMatcher, err error) {
+	inner, rest, ok := helper.BeforeAndAfterOutsideBrackets(closingParenthesisRegexp, s[len("rate("):])
+	if !ok {
+		err = fmt.Errorf("matcher %q: unterminated rate", s)
+		return
+	}
+	m.Matcher, err = scope.Parse(strings.TrimSpace(inner))
+	if err != nil {
+		return
+	}
+
+	rest = strings.TrimSpace(rest)
+	var operatorLength int
+	m.Operator, operatorLength = comparisonOperatorPrefix(rest)
+	if operatorLength == 0 {
+		err = fmt.Errorf("matcher %q: no valid comparison operator", s)
+		return
+	}
+	rate := strings.Split(strings.TrimSpace(rest[operatorLength:]), "/")
+	if len(rate) != 2 {
+		err = fmt.Errorf("matcher %q: no valid rate, such as 8/s or 3/500ms", s)
+		return
+	}
+	m.Count, err = ParseInteger(strings.TrimSpace(rate[0]))
+	if err != nil || m.Count < 0 {
+		err = fmt.Errorf("matcher %q: no valid rate count", s)
+		return
+	}
+	per := strings.TrimSpace(rate[1])
+	if !strings.ContainsAny(per, "0123456789") {
+		// 8/s is short for 8/1s.
+		per = "1" + per
+	}
+	m.Per, err = ParseDuration(per)
+	if err != nil || m.Per == 0 {
+		err = fmt.Errorf("matcher %q: no valid rate duration", s)
+	}
+	return
+}
+
+var closingParenthesisRegexp = regexp.MustCompile(`\)`)
+
+// identifierPrefix returns the identifier which s starts with, or "" if s does not start with an identifier.
+// An identifier is a letter or an underscore followed by any number of letters, digits and underscores.
+func identifierPrefix(s string) string {
+	for i, c := range s {
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// IsIdentifier reports whether s is an identifier, as described by identifierPrefix.
+func IsIdentifier(s string) bool {
+	return s != "" && identifierPrefix(s) == s
+}
+
 // skipToNonSpaceCharacter moves the start of *s to the next non-space character.
 // If there is a non-space character in *s, *s is modified such that the first character in *s is that non-space character, and true is returned.
 // Otherwise *s is not modified and false is returned.
@@ -105,16 +430,17 @@ func skipToNonSpaceCharacter(s *string) (ok bool) {
 // If s contains "&&" beforeAndAfterLogicalOperator returns before, after, LogicalAndOperator, where before is the substring of s which appear before "&&" and after is the substring of s which appear after "&&".
 // Otherwise if s contains "||" beforeAndAfterLogicalOperator returns before, after, LogicalOrOperator, where before is the substring of s which appear before "||" and after is the substring of s which appear after it.
 // If s contains neither "||" nor "&&", beforeAndAfterLogicalOperator returns "", "", NoLogicalOperator
+// Logical operators enclosed in parentheses or braces are not considered.
 func beforeAndAfterLogicalOperator(s string) (string, string, LogicalOperator) {
 	logicalAndRegexp := regexp.MustCompilePOSIX("&&")
 	logicalOrRegexp := regexp.MustCompilePOSIX(`\|\|`)
 
-	before, after, ok := helper.BeforeAndAfter(logicalAndRegexp, s)
+	before, after, ok := helper.BeforeAndAfterOutsideBrackets(logicalAndRegexp, s)
 	if ok {
 		return before, after, LogicalAndOperator
 	}
 
-	before, after, ok = helper.BeforeAndAfter(logicalOrRegexp, s)
+	before, after, ok = helper.BeforeAndAfterOutsideBrackets(logicalOrRegexp, s)
 	if ok {
 		return before, after, LogicalOrOperator
 	}
@@ -122,7 +448,8 @@ func beforeAndAfterLogicalOperator(s string) (string, string, LogicalOperator) {
 	return "", "", NoLogicalOperator
 }
 
-// Matcher is a discriminated union of MatcherWithoutLogicalOperator and MatcherWithLogicalOperator.
+// Matcher is a discriminated union of MatcherWithoutLogicalOperator, MatcherWithMembershipOperator,
+// MatcherWithLogicalOperator, ChordMatcher, TapsMatcher, CrossingMatcher and RateMatcher.
 //
 // This method of representing a syntax tree is based on the following article.
 // https://eli.thegreenplace.net/2018/go-and-algebraic-data-types/
@@ -132,12 +459,28 @@ type Matcher interface {
 	Equal(Matcher) bool
 }
 
+// Walk calls f for m and for every matcher which m is composed of, in depth-first order.
+func Walk(m Matcher, f func(Matcher)) {
+	f(m)
+	switch m := m.(type) {
+	case MatcherWithLogicalOperator:
+		Walk(m.LeftMatcher, f)
+		Walk(m.RightMatcher, f)
+	case RateMatcher:
+		Walk(m.Matcher, f)
+	}
+}
+
 // MatcherWithoutLogicalOperator represents a simple matcher with no logical operator, such as:
 // data1 == 1
 // data2 < 4
 // data1 != 37
+// mode == 2
+// held < 300ms
+// status == 0x99
+// An operand may have no value for some messages, in which case the matcher does not match them.
 type MatcherWithoutLogicalOperator struct {
-	LeftOperand  Data1OrData2
+	LeftOperand  Operand
 	Operator     ComparisonOperator
 	RightOperand int64
 }
@@ -146,20 +489,100 @@ type MatcherWithoutLogicalOperator struct {
 func (m MatcherWithoutLogicalOperator) Equal(n Matcher) bool {
 	mm, ok := n.(MatcherWithoutLogicalOperator)
 	return ok &&
-		m.LeftOperand == mm.LeftOperand &&
+		m.LeftOperand.Equal(mm.LeftOperand) &&
 		m.Operator == mm.Operator &&
 		m.RightOperand == mm.RightOperand
 }
 
 func (_ MatcherWithoutLogicalOperator) isMatcher() {}
 
-type Data1OrData2 int
+// Operand is a discriminated union of MessageByte, Variable, Duration and ControllerChange.
+type Operand interface {
+	isOperand()
+	Equal(Operand) bool
+}
+
+// MessageByte is an operand whose value is a byte of the message.
+type MessageByte int
 
 const (
-	Data1 Data1OrData2 = iota
+	Data1 MessageByte = iota
 	Data2
+	Status
+	// Pad is data1 of note on and note off messages, that is the note. It has no value for other messages.
+	Pad
+	// Control is data1 of control change messages, that is the controller. It has no value for other messages.
+	Control
 )
 
+// Equal reports whether o and p represent the same operand.
+func (o MessageByte) Equal(p Operand) bool {
+	pp, ok := p.(MessageByte)
+	return ok && o == pp
+}
+
+func (_ MessageByte) isOperand() {}
+
+// Duration is an operand whose value is a duration, which is compared in milliseconds.
+type Duration int
+
+const (
+	// Held is how long the note released by the message was held. It has no value for other messages.
+	Held Duration = iota
+	// Holding is how long the note pressed by the message has been held, while it is still held. It has a value only
+	// when the duration it is compared to has passed since the note was pressed without the note being released, at
+	// which point the message pressing the note is matched once more. It can therefore only be compared with ==, >= and
+	// >.
+	Holding
+)
+
+// Equal reports whether o and p represent the same operand.
+func (o Duration) Equal(p Operand) bool {
+	pp, ok := p.(Duration)
+	return ok && o == pp
+}
+
+func (_ Duration) isOperand() {}
+
+// ControllerChange is an operand whose value describes how the value of a controller, that is data2 of a control
+// change message, changed since the previous control change message of the same channel and controller.
+// It has no value for other messages, nor for the first control change message of a controller, except for Steps.
+type ControllerChange int
+
+const (
+	// PreviousData2 is the previous value of the controller.
+	PreviousData2 ControllerChange = iota
+	// Delta is the value of the controller less its previous value.
+	Delta
+	// Direction is the sign of Delta, that is -1 if the value decreased, 1 if it increased and 0 otherwise.
+	Direction
+	// Steps is how many steps an encoder was turned by, which is positive if it was turned clockwise, as decoded from
+	// data2 as declared by the encoder directive of the controller. It has no value for other controllers.
+	Steps
+)
+
+// Equal reports whether o and p represent the same operand.
+func (o ControllerChange) Equal(p Operand) bool {
+	pp, ok := p.(ControllerChange)
+	return ok && o == pp
+}
+
+func (_ ControllerChange) isOperand() {}
+
+// Variable represents reading the variable with a name of Name, as declared by a var directive.
+// Boolean variables are 1 when true and 0 when false.
+type Variable struct {
+	Name string
+}
+
+// Equal reports whether o and p represent the same operand.
+func (o Variable) Equal(p Operand) bool {
+	pp, ok := p.(Variable)
+	return ok && o == pp
+}
+
+func (_ Variable) isOperand() {}
+
 type ComparisonOperator int
 
 const (
@@ -171,6 +594,51 @@ const (
 	GreaterThanOperator
 )
 
+// MatcherWithMembershipOperator represents a matcher testing whether an operand is in a set, such as:
+// data1 in 36..48
+// data1 in {37, 38, 40}
+// data2 not in {1..20, 127}
+// The set is a union of inclusive ranges, where a single value is a range with the same Low and High.
+type MatcherWithMembershipOperator struct {
+	LeftOperand Operand
+	Operator    MembershipOperator
+	Set         []Range
+}
+
+// Equal reports whether m and n represent the same matcher.
+func (m MatcherWithMembershipOperator) Equal(n Matcher) bool {
+	mm, ok := n.(MatcherWithMembershipOperator)
+	if !ok || !m.LeftOperand.Equal(mm.LeftOperand) || m.Operator != mm.Operator || len(m.Set) != len(mm.Set) {
+		return false
+	}
+	for i := range m.Set {
+		if m.Set[i] != mm.Set[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (_ MatcherWithMembershipOperator) isMatcher() {}
+
+// Range is an inclusive range of values.
+type Range struct {
+	Low  int64
+	High int64
+}
+
+// Contains reports whether n is in r.
+func (r Range) Contains(n int64) bool {
+	return r.Low <= n && n <= r.High
+}
+
+type MembershipOperator int
+
+const (
+	InOperator MembershipOperator = iota
+	NotInOperator
+)
+
 // MatcherWithLogicalOperator represents a matcher with at least one logical operator, such as:
 // data1 == 1 || data1 > 10
 // (data1 != 1 && data1 != 51) || data2 > 10
@@ -199,3 +667,112 @@ const (
 	LogicalOrOperator
 	NoLogicalOperator LogicalOperator = -1
 )
+
+// ChordMatcher represents a chord matcher, such as:
+// chord(36, 40, 43)
+// chord(36, 40, 43) within 40ms
+// chord(36, 40, 43) within 40ms exclusive
+// It matches the note on which completes the chord, that is the note on after which every note of the chord is held on
+// its channel, and the notes were pressed no more than Within apart. A Within of 0 puts no limit on how far apart they
+// are pressed.
+//
+// The notes of an Exclusive chord do not match anything else when they complete the chord, or are followed by the
+// note on completing it within Within.
+type ChordMatcher struct {
+	Notes     []int64
+	Within    time.Duration
+	Exclusive bool
+}
+
+// Equal reports whether m and n represent the same matcher.
+func (m ChordMatcher) Equal(n Matcher) bool {
+	mm, ok := n.(ChordMatcher)
+	if !ok || len(m.Notes) != len(mm.Notes) || m.Within != mm.Within || m.Exclusive != mm.Exclusive {
+		return false
+	}
+	for i := range m.Notes {
+		if m.Notes[i] != mm.Notes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (_ ChordMatcher) isMatcher() {}
+
+// TapsMatcher represents a taps matcher, such as:
+// taps(2) within 250ms
+// taps(1) within 250ms
+// taps(3) within 250ms immediate
+// The note ons of a note are counted as taps as long as each follows the previous within Within.
+//
+// Unless it is Immediate, a taps matcher matches the last tap once Within has passed without another tap, if there were
+// Count taps. This way a single tap does not match taps(1) when it is followed by a second tap, which matches taps(2)
+// instead. An Immediate taps matcher matches the tap which is the Count-th tap right away.
+type TapsMatcher struct {
+	Count     int64
+	Within    time.Duration
+	Immediate bool
+}
+
+// Equal reports whether m and n represent the same matcher.
+func (m TapsMatcher) Equal(n Matcher) bool {
+	mm, ok := n.(TapsMatcher)
+	return ok && m == mm
+}
+
+func (_ TapsMatcher) isMatcher() {}
+
+// CrossingMatcher represents a crossing matcher, such as:
+// crosses up 100
+// crosses up 100 rearm 90
+// crosses down 20 rearm 30
+// It matches the control change message whose value, that is data2, crosses Threshold in Direction, meaning that it is
+// at least Threshold if Direction is UpDirection, or at most Threshold if it is DownDirection.
+// It does not match any more control change messages of the same channel and controller until the value of the
+// controller has crossed back to the Rearm level, which is one less than Threshold, or one more if the direction is
+// down, unless it is given.
+type CrossingMatcher struct {
+	Direction CrossingDirection
+	Threshold int64
+	Rearm     int64
+}
+
+// Equal reports whether m and n represent the same matcher.
+func (m CrossingMatcher) Equal(n Matcher) bool {
+	mm, ok := n.(CrossingMatcher)
+	return ok && m == mm
+}
+
+func (_ CrossingMatcher) isMatcher() {}
+
+type CrossingDirection int
+
+const (
+	UpDirection   CrossingDirection = 1
+	DownDirection CrossingDirection = -1
+)
+
+// RateMatcher represents a rate matcher, such as:
+// rate(data1 == 38) >= 8/s
+// rate(data1 == 38 && data2 > 0) < 3/500ms
+// It matches every message while the number of messages matched by Matcher within the last Per, that is the rate of
+// those messages, compares to Count by Operator.
+type RateMatcher struct {
+	Matcher  Matcher
+	Operator ComparisonOperator
+	Count    int64
+	Per      time.Duration
+}
+
+// Equal reports whether m and n represent the same matcher.
+func (m RateMatcher) Equal(n Matcher) bool {
+	mm, ok := n.(RateMatcher)
+	return ok &&
+		m.Matcher.Equal(mm.Matcher) &&
+		m.Operator == mm.Operator &&
+		m.Count == mm.Count &&
+		m.Per == mm.Per
+}
+
+func (_ RateMatcher) isMatcher() {}
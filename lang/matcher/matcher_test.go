@@ -3,6 +3,7 @@ package matcher
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 // Test that Parse parses a simple valid matcher correctly.
@@ -111,7 +112,7 @@ func TestParseNoLeftOperand(t *testing.T) {
 func TestParseNo(t *testing.T) {
 	s := "data1 >"
 
-	var wantedErr error = fmt.Errorf("matcher %q: no valid right operand", s)
+	var wantedErr error = fmt.Errorf("matcher %q: no valid right operand: integer \"\": invalid", s)
 
 	_, err := Parse(s)
 
@@ -121,3 +122,450 @@ func TestParseNo(t *testing.T) {
 		t.Errorf("Parse(%q) returns incorrect error %q, want %q.", s, err, wantedErr)
 	}
 }
+
+// Test that Parse parses a matcher, reading a variable, correctly.
+func TestParseVariable(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Variable{"mode"}, EqualToOperator, 2},
+		LogicalAndOperator,
+		MatcherWithoutLogicalOperator{Variable{"muted"}, EqualToOperator, 0},
+	}
+
+	s := "mode == 2 && muted == false"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a chord matcher, combined with another matcher, correctly.
+func TestParseChord(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		ChordMatcher{[]int64{36, 40, 43}, 40 * time.Millisecond, true},
+		LogicalAndOperator,
+		MatcherWithoutLogicalOperator{Variable{"mode"}, EqualToOperator, 1},
+	}
+
+	s := "chord(36, 40, 43) within 40ms exclusive && mode == 1"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a chord matcher, with a single note, correctly.
+func TestParseChordSingleNote(t *testing.T) {
+	s := "chord(36) within 40ms"
+	wantedErr := fmt.Errorf("matcher %q: a chord must have at least two notes", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a matcher, comparing a duration, correctly.
+func TestParseDuration(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Data1, EqualToOperator, 44},
+		LogicalAndOperator,
+		MatcherWithoutLogicalOperator{Held, GreaterThanOrEqualToOperator, 1500},
+	}
+
+	s := "data1 == 44 && held >= 1.5s"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a matcher, comparing a duration to an integer, correctly.
+func TestParseDurationInteger(t *testing.T) {
+	s := "holding >= 300"
+	wantedErr := fmt.Errorf("matcher %q: no valid right operand: duration \"300\": invalid", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a taps matcher correctly.
+func TestParseTaps(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Data1, EqualToOperator, 36},
+		LogicalAndOperator,
+		TapsMatcher{2, 250 * time.Millisecond, false},
+	}
+
+	s := "data1 == 36 && taps(2) within 250ms"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a taps matcher, lacking a duration, correctly.
+func TestParseTapsNoWithin(t *testing.T) {
+	s := "taps(2)"
+	wantedErr := fmt.Errorf("matcher %q: taps without within", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses matchers with membership operators correctly.
+func TestParseMembership(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithMembershipOperator{Data1, InOperator, []Range{{37, 37}, {38, 38}, {40, 42}}},
+		LogicalAndOperator,
+		MatcherWithMembershipOperator{Data2, NotInOperator, []Range{{1, 20}}},
+	}
+
+	s := "data1 in {37, 38, 40..42} && data2 not in 1..20"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a matcher, with a membership operator and an empty range, correctly.
+func TestParseMembershipEmptyRange(t *testing.T) {
+	s := "data1 in 48..36"
+	wantedErr := fmt.Errorf("matcher %q: no valid set element %q", s, "48..36")
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses matchers with note names and hexadecimal and binary literals correctly.
+func TestParseLiterals(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Status, EqualToOperator, 0x99},
+		LogicalAndOperator,
+		MatcherWithLogicalOperator{
+			MatcherWithMembershipOperator{Data1, InOperator, []Range{{37, 38}, {39, 39}}},
+			LogicalAndOperator,
+			MatcherWithoutLogicalOperator{Data2, GreaterThanOperator, 5},
+		},
+	}
+
+	s := "status == 0x99 && data1 in {C#2..D2, Eb2} && data2 > 0b101"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a note name in a scope with another octave of middle C correctly.
+func TestParseNoteNameInScope(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithoutLogicalOperator{Data1, EqualToOperator, 60}
+
+	s := "data1 == C3"
+	matcher, err := Scope{MiddleCOctave: 3}.Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a matcher, with a hexadecimal literal out of range, correctly.
+func TestParseLiteralOutOfRange(t *testing.T) {
+	s := "data2 == 0x80"
+	wantedErr := fmt.Errorf("matcher %q: no valid right operand: literal \"0x80\": out of range 0 to 127", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a matcher, comparing holding with less than, correctly.
+func TestParseHoldingLessThan(t *testing.T) {
+	s := "holding < 300ms"
+	wantedErr := fmt.Errorf("matcher %q: holding can only be compared with ==, >= or >", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a matcher, testing holding for membership, correctly.
+func TestParseHoldingIn(t *testing.T) {
+	s := "holding in 100ms..300ms"
+	wantedErr := fmt.Errorf("matcher %q: holding can only be compared with ==, >= or >", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a matcher, using a constant and a definition, correctly.
+func TestParseNames(t *testing.T) {
+	var wantedErr error = nil
+	hard := MatcherWithoutLogicalOperator{Data2, GreaterThanOrEqualToOperator, 80}
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Data1, EqualToOperator, 38},
+		LogicalAndOperator,
+		hard,
+	}
+	scope := Scope{
+		Constants:   map[string]int64{"snare": 38},
+		Definitions: map[string]Matcher{"hard": hard},
+	}
+
+	s := "data1 == snare && hard"
+	matcher, err := scope.Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a matcher, comparing to an undefined name, correctly.
+func TestParseUndefinedName(t *testing.T) {
+	s := "data1 == snare"
+	wantedErr := fmt.Errorf("matcher %q: undefined name %q", s, "snare")
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a matcher, comparing pad and control operands to the names of a device, correctly.
+func TestParseDeviceNames(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithMembershipOperator{LeftOperand: Pad, Operator: InOperator, Set: []Range{{38, 38}, {43, 43}}},
+		LogicalOrOperator,
+		MatcherWithoutLogicalOperator{Control, EqualToOperator, 4},
+	}
+	scope := Scope{
+		Pads:     map[string]int64{"snare": 38, "floor_tom": 43},
+		Controls: map[string]int64{"hihat": 4},
+	}
+
+	s := "pad in {snare, floor_tom} || control == hihat"
+	matcher, err := scope.Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a matcher, comparing controller changes, correctly.
+func TestParseControllerChanges(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Data1, EqualToOperator, 4},
+		LogicalAndOperator,
+		MatcherWithLogicalOperator{
+			MatcherWithoutLogicalOperator{Direction, EqualToOperator, 1},
+			LogicalAndOperator,
+			MatcherWithLogicalOperator{
+				MatcherWithoutLogicalOperator{Delta, GreaterThanOperator, 10},
+				LogicalAndOperator,
+				MatcherWithoutLogicalOperator{PreviousData2, LessThanOperator, 0x40},
+			},
+		},
+	}
+
+	s := "data1 == 4 && direction == 1 && delta > 10 && prev(data2) < 0x40"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a crossing matcher, without a rearm level, correctly.
+func TestParseCrossingMatcher(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		MatcherWithoutLogicalOperator{Data1, EqualToOperator, 7},
+		LogicalAndOperator,
+		CrossingMatcher{DownDirection, 20, 21},
+	}
+
+	s := "data1 == 7 && crosses down 20"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that Parse parses a crossing matcher, with a rearm level past the threshold, correctly.
+func TestParseCrossingMatcherRearm(t *testing.T) {
+	s := "crosses up 100 rearm 110"
+	wantedErr := fmt.Errorf("matcher %q: the rearm level must be on the other side of the threshold", s)
+
+	_, err := Parse(s)
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
+
+// Test that Parse parses a rate matcher, whose matcher has a logical operator, correctly.
+func TestParseRateMatcher(t *testing.T) {
+	var wantedErr error = nil
+	wantedMatcher := MatcherWithLogicalOperator{
+		RateMatcher{
+			MatcherWithLogicalOperator{
+				MatcherWithoutLogicalOperator{Data1, EqualToOperator, 38},
+				LogicalAndOperator,
+				MatcherWithoutLogicalOperator{Data2, UnequalToOperator, 0},
+			},
+			GreaterThanOrEqualToOperator,
+			8,
+			time.Second,
+		},
+		LogicalOrOperator,
+		RateMatcher{MatcherWithoutLogicalOperator{Data1, EqualToOperator, 36}, LessThanOperator, 3, 500 * time.Millisecond},
+	}
+
+	s := "rate(data1 == 38 && data2 != 0) >= 8/s || rate(data1 == 36) < 3/500ms"
+	matcher, err := Parse(s)
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !matcher.Equal(wantedMatcher) {
+		t.Errorf("Parse(%q) returns an incorrect matcher %v, want %v.", s, matcher, wantedMatcher)
+	}
+}
+
+// Test that ParseExpression parses an expression, whose operators have different precedences, correctly.
+func TestParseExpression(t *testing.T) {
+	var wantedErr error = nil
+	wantedExpression := BinaryExpression{
+		BinaryExpression{
+			OperandExpression{Data2},
+			MultiplyOperator,
+			Constant{2},
+		},
+		AddOperator,
+		BinaryExpression{
+			BinaryExpression{OperandExpression{Variable{"mode"}}, SubtractOperator, Constant{1}},
+			DivideOperator,
+			Constant{1000},
+		},
+	}
+
+	s := "data2 * 2 + (mode - 1) / 1s"
+	expression, err := ParseExpression(s)
+
+	if err != wantedErr {
+		t.Errorf("ParseExpression(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !expression.Equal(wantedExpression) {
+		t.Errorf("ParseExpression(%q) returns an incorrect expression %v, want %v.", s, expression, wantedExpression)
+	}
+}
+
+// Test that ParseExpression parses an expression, with an unterminated parenthesis, correctly.
+func TestParseExpressionUnterminated(t *testing.T) {
+	s := "(data2 * 2"
+	wantedErr := fmt.Errorf("expression %q: unterminated parenthesis", s)
+
+	_, err := ParseExpression(s)
+
+	if err == nil {
+		t.Errorf("ParseExpression(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("ParseExpression(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
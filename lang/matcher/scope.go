@@ -0,0 +1,39 @@
+package matcher
+
+// Scope is what a matcher is parsed in, which decides the meaning of some of the matcher.
+type Scope struct {
+	// MiddleCOctave is the octave of middle C, that is note 60, in note names. It is 4 in scientific pitch notation,
+	// and 3 in the convention of many manufacturers.
+	MiddleCOctave int64
+	// Constants are the values of the names defined by let directives, which may be compared to.
+	Constants map[string]int64
+	// Definitions are the matchers of the names defined by def directives, which may be used as matchers themselves.
+	Definitions map[string]Matcher
+	// Pads and Controls are the notes and controllers named by the device profile of the map, which pad and control
+	// operands respectively may be compared to by name.
+	Pads     map[string]int64
+	Controls map[string]int64
+}
+
+// IsDefined reports whether name is defined in scope, as either a constant or a definition.
+func (scope Scope) IsDefined(name string) bool {
+	_, isConstant := scope.Constants[name]
+	_, isDefinition := scope.Definitions[name]
+	return isConstant || isDefinition
+}
+
+// ParseValue parses a value, that is an integer, a literal or a constant, which a let directive may define a constant
+// as.
+func (scope Scope) ParseValue(s string) (int64, error) {
+	return scope.parseLiteral(nil, s)
+}
+
+// ParseControl is like ParseValue, but parses s as the number of a controller, which may also be a control of scope.
+func (scope Scope) ParseControl(s string) (int64, error) {
+	return scope.parseLiteral(Control, s)
+}
+
+// DefaultScope is the scope of a map which does not say otherwise.
+var DefaultScope = Scope{
+	MiddleCOctave: 4,
+}
@@ -0,0 +1,114 @@
+// The message package parses the MIDI messages which midimap sends, such as those of reverse maps and send actions.
+package message
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+)
+
+// Message is a MIDI message sent to turn something on, such as while a key is pressed, along with the message sent to
+// turn it off.
+type Message struct {
+	Kind Kind
+	// Channel is the channel of the message, from 0 to 15, although it is written from 1 to 16.
+	Channel int64
+	// Number is the note or controller of the message.
+	Number int64
+	// Value is the velocity of a note, or the value of a controller while it is on.
+	Value int64
+}
+
+// On returns the raw message turning m on.
+func (m Message) On() []byte {
+	switch m.Kind {
+	case NoteKind:
+		return []byte{0x90 | byte(m.Channel), byte(m.Number), byte(m.Value)}
+	case ControlKind:
+		return []byte{0xb0 | byte(m.Channel), byte(m.Number), byte(m.Value)}
+	default:
+		panic("unreachable")
+	}
+}
+
+// Off returns the raw message turning m off.
+func (m Message) Off() []byte {
+	switch m.Kind {
+	case NoteKind:
+		return []byte{0x80 | byte(m.Channel), byte(m.Number), 0}
+	case ControlKind:
+		return []byte{0xb0 | byte(m.Channel), byte(m.Number), 0}
+	default:
+		panic("unreachable")
+	}
+}
+
+type Kind int
+
+const (
+	NoteKind Kind = iota
+	ControlKind
+)
+
+// kinds are the kinds of messages by name.
+var kinds = map[string]Kind{
+	"note":    NoteKind,
+	"control": ControlKind,
+}
+
+// Parse parses a message, that is note or control, a note or controller and optionally options, such as:
+// note 36
+// note C2 velocity 100 channel 10
+// control 64 value 90
+// The velocity or value defaults to 127 and the channel to 1.
+//
+// If s is a valid message, Parse returns message, nil.
+// Otherwise, Parse returns an error describing why the message is invalid.
+func Parse(s string) (m Message, err error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		err = fmt.Errorf("message %q: a message takes note or control, a number and optionally options", s)
+		return
+	}
+	var ok bool
+	m.Kind, ok = kinds[fields[0]]
+	if !ok {
+		err = fmt.Errorf("message %q: unknown kind %q", s, fields[0])
+		return
+	}
+	m.Number, err = matcher.DefaultScope.ParseValue(fields[1])
+	if err != nil || m.Number < 0 || m.Number > 127 {
+		err = fmt.Errorf("message %q: no valid number", s)
+		return
+	}
+
+	m.Value = 127
+	channel := int64(1)
+	given := make(map[string]bool)
+	for i := 2; i < len(fields); i += 2 {
+		option := fields[i]
+		if given[option] {
+			err = fmt.Errorf("message %q: %s given more than once", s, option)
+			return
+		}
+		given[option] = true
+		var n int64
+		n, err = matcher.ParseInteger(fields[i+1])
+		switch {
+		case option == "channel" && err == nil && n >= 1 && n <= 16:
+			channel = n
+		case option == "velocity" && m.Kind == NoteKind && err == nil && n >= 1 && n <= 127,
+			option == "value" && m.Kind == ControlKind && err == nil && n >= 0 && n <= 127:
+			m.Value = n
+		case option == "channel" || option == "velocity" || option == "value":
+			err = fmt.Errorf("message %q: no valid %s", s, option)
+			return
+		default:
+			err = fmt.Errorf("message %q: invalid option %q", s, option)
+			return
+		}
+	}
+	m.Channel = channel - 1
+	return
+}
@@ -0,0 +1,103 @@
+// The reverse package parses reverse maps, which describe the MIDI messages sent when the keys of a keyboard are
+// pressed and released, as is done by midimap reverse.
+//
+// A reverse map consists of lines such as:
+//
+//	# comment
+//	f -> note 36
+//	space -> note C2 velocity 100 channel 10
+//	b -> control 64
+//
+// where a note is on while the key is pressed, and a control is at its value while the key is pressed and at 0
+// otherwise. A key may have several lines, whose messages are sent in order.
+package reverse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fossegrim/midimap/lang/keycode"
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Map represents a reverse map.
+type Map struct {
+	Mappings []Mapping
+}
+
+// Mapping is a line of a reverse map, which sends Message for the key with a keycode of Keycode.
+type Mapping struct {
+	Keycode int
+	Message message.Message
+}
+
+// Messages returns the messages sent for the key with a keycode of keycode, in order.
+func (m Map) Messages(keycode int) (messages []message.Message) {
+	for _, mp := range m.Mappings {
+		if mp.Keycode == keycode {
+			messages = append(messages, mp.Message)
+		}
+	}
+	return
+}
+
+// Parse parses a reverse map, by parsing every line read from r.
+//
+// If r is a valid reverse map, Parse returns m, nil.
+// Otherwise, Parse returns an error describing the first line which is invalid, or the io error which occurred.
+func Parse(r io.Reader) (m Map, err error) {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		// skip blank lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var mp Mapping
+		mp, err = parseMapping(line)
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", lineNumber, err)
+			return
+		}
+		m.Mappings = append(m.Mappings, mp)
+	}
+	err = scanner.Err()
+	return
+}
+
+// ParseFile is like Parse, but parses the reverse map read from the file named name.
+func ParseFile(name string) (m Map, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	m, err = Parse(f)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return
+}
+
+// parseMapping parses a line of a reverse map, such as f -> note 36 velocity 100.
+func parseMapping(s string) (mp Mapping, err error) {
+	i := strings.Index(s, "->")
+	if i == -1 {
+		err = fmt.Errorf("reverse mapping %q: no valid separator", s)
+		return
+	}
+	mp.Keycode, err = keycode.Parse(strings.TrimSpace(s[:i]))
+	if err != nil {
+		err = fmt.Errorf("reverse mapping %q: %v", s, err)
+		return
+	}
+
+	mp.Message, err = message.Parse(strings.TrimSpace(s[i+len("->"):]))
+	if err != nil {
+		err = fmt.Errorf("reverse mapping %q: %v", s, err)
+	}
+	return
+}
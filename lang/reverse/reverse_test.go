@@ -0,0 +1,45 @@
+package reverse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fossegrim/midimap/lang/message"
+)
+
+// Test that Parse parses a simple valid reverse map correctly.
+func TestParse(t *testing.T) {
+	var wantedErr error = nil
+	wantedMap := Map{[]Mapping{
+		{33, message.Message{message.NoteKind, 0, 36, 127}},
+		{57, message.Message{message.NoteKind, 9, 38, 100}},
+		{48, message.Message{message.ControlKind, 0, 64, 127}},
+	}}
+
+	s := "# Foot switch\nf -> note 36\n\nspace -> note D2 velocity 100 channel 10\nb -> control 64"
+	m, err := Parse(strings.NewReader(s))
+
+	if err != wantedErr {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %v.", s, err, wantedErr)
+	}
+
+	if !reflect.DeepEqual(m, wantedMap) {
+		t.Errorf("Parse(%q) returns an incorrect map %v, want %v.", s, m, wantedMap)
+	}
+}
+
+// Test that Parse parses a reverse map, giving a note a value, correctly.
+func TestParseNoteValue(t *testing.T) {
+	s := "f -> note 36 value 100"
+	wantedErr := fmt.Errorf("line 1: reverse mapping %q: message %q: no valid value", s, "note 36 value 100")
+
+	_, err := Parse(strings.NewReader(s))
+
+	if err == nil {
+		t.Errorf("Parse(%q) returns an incorrect error %v, want %q.", s, err, wantedErr)
+	} else if err.Error() != wantedErr.Error() {
+		t.Errorf("Parse(%q) returns an incorrect error %q, want %q.", s, err, wantedErr)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/keycode"
+)
+
+// toggleKey holds down the key of a, a toggle key action, if it is not latched, and releases it otherwise.
+func (e *engine) toggleKey(a action.KeyAction) error {
+	key := a.Key()
+	for i, l := range e.latched {
+		if l == key {
+			e.latched = append(e.latched[:i], e.latched[i+1:]...)
+			e.reportLatched()
+			return release(e.kb, key)
+		}
+	}
+	if err := pressDown(e.kb, key); err != nil {
+		return err
+	}
+	e.latched = append(e.latched, key)
+	e.reportLatched()
+	return nil
+}
+
+// reportLatched describes the latched keys, in the order they were latched, if e is verbose.
+func (e *engine) reportLatched() {
+	if !e.verbose {
+		return
+	}
+	names := make([]string, len(e.latched))
+	for i, key := range e.latched {
+		names[i] = keyName(key)
+	}
+	if len(names) == 0 {
+		names = []string{"none"}
+	}
+	fmt.Fprintf(os.Stderr, "latched: %s\n", strings.Join(names, ", "))
+}
+
+// keyName returns the name of the key of a, as it would be written in a map.
+func keyName(a action.KeyAction) string {
+	var names []string
+	for _, m := range []struct {
+		modifier action.Modifiers
+		name     string
+	}{
+		{action.CtrlModifier, "ctrl"},
+		{action.ShiftModifier, "shift"},
+		{action.AltModifier, "alt"},
+		{action.SuperModifier, "super"},
+	} {
+		if a.Modifiers&m.modifier != 0 {
+			names = append(names, m.name)
+		}
+	}
+	name, ok := keycode.Name(a.Keycode)
+	if !ok {
+		name = strconv.Itoa(a.Keycode)
+	}
+	return strings.Join(append(names, name), "+")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/mapping"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// listKey identifies the cursor of a list action of a mapping, by the index of the list action in the order
+// action.Walk walks the actions of the mapping in.
+type listKey struct {
+	mapping *mapping.Mapping
+	at      int
+}
+
+// listCursor is the state of a list action of a mapping.
+type listCursor struct {
+	// next is the index of the action which is performed next.
+	next int
+	// backwards is whether an action list with the alternate policy is being gone through in reverse.
+	backwards bool
+}
+
+// selectAction returns the action of a, a list action of mp at the index at, which is performed this time, along with
+// its index.
+func (e *engine) selectAction(a action.ListAction, at int, mp *mapping.Mapping) (action.Action, int) {
+	if a.Policy == action.RandomPolicy {
+		i := rand.Intn(len(a.Actions))
+		return a.Actions[i], elementIndex(a, at, i)
+	}
+
+	k := listKey{mp, at}
+	c, ok := e.cursors[k]
+	if !ok {
+		c = &listCursor{}
+		e.cursors[k] = c
+	}
+	selected, selectedAt := a.Actions[c.next], elementIndex(a, at, c.next)
+	switch a.Policy {
+	case action.CyclePolicy:
+		c.next = (c.next + 1) % len(a.Actions)
+	case action.AlternatePolicy:
+		if c.backwards && c.next == 0 || !c.backwards && c.next == len(a.Actions)-1 {
+			c.backwards = !c.backwards
+		}
+		if c.backwards {
+			c.next--
+		} else {
+			c.next++
+		}
+	default:
+		panic("unreachable")
+	}
+	return selected, selectedAt
+}
+
+// elementIndex returns the index of the i-th action of a, a list action at the index at.
+func elementIndex(a action.ListAction, at int, i int) int {
+	at++
+	for _, b := range a.Actions[:i] {
+		at += actionSize(b)
+	}
+	return at
+}
+
+// actionSize returns the number of actions action.Walk walks for a, that is a and every action it is composed of.
+func actionSize(a action.Action) (n int) {
+	action.Walk(a, func(action.Action) {
+		n++
+	})
+	return
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/fossegrim/midimap/lang"
+	"github.com/fossegrim/midimap/lang/device"
+	"github.com/fossegrim/midimap/lang/mapping"
 	"github.com/fossegrim/midimap/lang/matcher"
+	"github.com/micmonay/keybd_event"
 	"gitlab.com/gomidi/midi"
 	"gitlab.com/gomidi/midi/reader"
 )
@@ -15,15 +20,28 @@ import (
 // For documentation about the log command modifier itself, consult midimap(1).
 func logCommandModifier(args []string) error {
 	// Parse args
+	var dev device.Device
+	scope := matcher.DefaultScope
+	if len(args) >= 2 && args[0] == "-device" {
+		var err error
+		dev, err = device.Load(args[1])
+		if err != nil {
+			return err
+		}
+		scope.Pads = dev.Pads
+		scope.Controls = dev.Controls
+		args = args[2:]
+	}
 	var m matcher.Matcher
 	var receivedMatcher bool
 	switch len(args) {
 	case 2:
 		var err error
-		m, err = matcher.Parse(args[1])
+		m, err = scope.Parse(args[1])
 		if err != nil {
 			return err
 		}
+		receivedMatcher = true
 	case 1:
 	default:
 		return errUsage
@@ -55,15 +73,25 @@ func logCommandModifier(args []string) error {
 	}
 	defer in.Close()
 
+	// The matcher is that of the only mapping of a map, so that it behaves exactly as it would in a map, including
+	// matching after a delay, as taps and holding matchers do.
+	var lm lang.Map
+	if receivedMatcher {
+		lm.Layers = []lang.Layer{{Name: lang.BaseLayer, Mappings: []mapping.Mapping{{Matcher: m}}}}
+	}
+	e := newEngine(&keybd_event.KeyBonding{}, lm)
+	e.matched = func(msg midi.Message) {
+		printMessage(dev, msg)
+	}
 	rd := reader.New(
 		reader.NoLogger(),
 		reader.Each(func(pos *reader.Position, msg midi.Message) {
-			if !receivedMatcher || matcherMatchesMessage(m, msg) {
-				fmt.Println("---===---")
-				fmt.Printf("%s\n", msg)
-				fmt.Printf("status: %d\n", msg.Raw()[0])
-				fmt.Printf("data1: %d\n", msg.Raw()[1])
-				fmt.Printf("data2: %d\n", msg.Raw()[2])
+			if !receivedMatcher {
+				printMessage(dev, msg)
+				return
+			}
+			if err := e.mapMIDIMessageToKeyPress(msg); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
 			}
 		}),
 	)
@@ -82,3 +110,27 @@ func logCommandModifier(args []string) error {
 		}
 	}
 }
+
+// printMessage prints msg, along with the name which dev gives its pad or control, if any.
+func printMessage(dev device.Device, msg midi.Message) {
+	fmt.Println("---===---")
+	fmt.Printf("%s\n", msg)
+	fmt.Printf("status: %d\n", msg.Raw()[0])
+	fmt.Printf("data1: %d\n", msg.Raw()[1])
+	fmt.Printf("data2: %d\n", msg.Raw()[2])
+	if name, ok := deviceName(dev, msg); ok {
+		fmt.Printf("name: %s\n", name)
+	}
+}
+
+// deviceName returns the name which dev gives the pad or control of msg, and whether it names it at all.
+func deviceName(dev device.Device, msg midi.Message) (string, bool) {
+	if n, _, ok := noteOf(msg); ok {
+		return dev.PadName(int64(n.key))
+	}
+	raw := msg.Raw()
+	if len(raw) >= 2 && raw[0]&0xf0 == 0xb0 {
+		return dev.ControlName(int64(raw[1]))
+	}
+	return "", false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/action"
+	"github.com/fossegrim/midimap/lang/mapping"
+	"gitlab.com/gomidi/midi"
+)
+
+// macroRunner performs the actions of the mappings of an engine which wait, called macros, on goroutines of their own,
+// so that waiting does not delay the evaluation of the messages which arrive meanwhile.
+// The macros of a mapping are performed one after the other, while those of different mappings are performed at the
+// same time.
+type macroRunner struct {
+	e *engine
+	// queues are the macros of each mapping which are being or are yet to be performed. It is guarded by e.mu, along
+	// with the queues themselves and closed.
+	queues map[*mapping.Mapping]*macroQueue
+	closed bool
+	// running counts the goroutines performing macros.
+	running sync.WaitGroup
+}
+
+// macroQueue are the macros of a mapping, which are performed by a goroutine of their own as long as there are any.
+type macroQueue struct {
+	// current is the macro being performed, if any, and queue are those yet to be performed.
+	current *macroRun
+	queue   []*macroRun
+}
+
+// macroRun is the macro of a mapping to be performed in response to msg.
+type macroRun struct {
+	mapping *mapping.Mapping
+	msg     midi.Message
+	// cancel is closed to stop performing the macro.
+	cancel chan struct{}
+}
+
+func newMacroRunner(e *engine) *macroRunner {
+	return &macroRunner{e: e, queues: make(map[*mapping.Mapping]*macroQueue)}
+}
+
+// start performs the macro of mp in response to msg once the macros of mp before it are performed, unless the
+// retrigger option of mp says otherwise. It must be called with e.mu held.
+func (r *macroRunner) start(mp *mapping.Mapping, msg midi.Message) {
+	if r.closed {
+		return
+	}
+	q := r.queues[mp]
+	switch mp.Retrigger {
+	case mapping.QueueRetrigger:
+	case mapping.RestartRetrigger:
+		if q != nil {
+			q.cancel()
+		}
+	case mapping.IgnoreRetrigger:
+		if q != nil {
+			return
+		}
+	default:
+		panic("unreachable")
+	}
+	run := &macroRun{mp, msg, make(chan struct{})}
+	if q != nil {
+		q.queue = append(q.queue, run)
+		return
+	}
+	q = &macroQueue{queue: []*macroRun{run}}
+	r.queues[mp] = q
+	r.running.Add(1)
+	go r.run(mp, q)
+}
+
+// cancel stops performing the current macro of q, and drops those which are yet to be performed.
+// It must be called with e.mu held.
+func (q *macroQueue) cancel() {
+	if q.current != nil {
+		close(q.current.cancel)
+		q.current = nil
+	}
+	q.queue = nil
+}
+
+// cancel stops performing every macro, and drops those which are yet to be performed.
+// It must be called with e.mu held.
+func (r *macroRunner) cancel() {
+	for _, q := range r.queues {
+		q.cancel()
+	}
+}
+
+// close cancels the macros, and waits for the goroutines performing them to return.
+// It must not be called with e.mu held.
+func (r *macroRunner) close() {
+	r.e.mu.Lock()
+	r.cancel()
+	r.closed = true
+	r.e.mu.Unlock()
+	r.running.Wait()
+}
+
+// run performs the macros of q, the queue of mp, until there are none left.
+func (r *macroRunner) run(mp *mapping.Mapping, q *macroQueue) {
+	defer r.running.Done()
+	r.e.mu.Lock()
+	for {
+		if len(q.queue) == 0 || r.closed {
+			if r.queues[mp] == q {
+				delete(r.queues, mp)
+			}
+			r.e.mu.Unlock()
+			return
+		}
+		run := q.queue[0]
+		q.queue = q.queue[1:]
+		q.current = run
+		r.e.mu.Unlock()
+
+		r.perform(run)
+
+		r.e.mu.Lock()
+		if q.current == run {
+			q.current = nil
+		}
+	}
+}
+
+// perform performs the actions of run, until it is cancelled.
+func (r *macroRunner) perform(run *macroRun) {
+	var next int
+	for _, a := range run.mapping.Actions {
+		at := next
+		next += actionSize(a)
+		if w, ok := a.(action.WaitAction); ok {
+			select {
+			case <-time.After(w.Duration):
+				continue
+			case <-run.cancel:
+				return
+			}
+		}
+
+		r.e.mu.Lock()
+		select {
+		case <-run.cancel:
+			r.e.mu.Unlock()
+			return
+		default:
+		}
+		r.e.report(r.e.perform(a, at, run.mapping, run.msg))
+		r.e.updateFeedback()
+		r.e.mu.Unlock()
+	}
+}
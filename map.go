@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 
 	"github.com/fossegrim/midimap/lang"
-	"github.com/fossegrim/midimap/lang/mapping"
+	"github.com/fossegrim/midimap/lang/action"
 	"github.com/micmonay/keybd_event"
 	"gitlab.com/gomidi/midi"
 	"gitlab.com/gomidi/midi/reader"
@@ -20,6 +22,23 @@ import (
 //
 // For documentation about the map command modifier itself, see midimap(1).
 func mapCommandModifier(args []string) error {
+	var verbose bool
+	var outPortNumber *uint64
+	for len(args) > 0 {
+		if args[0] == "-verbose" || args[0] == "--verbose" {
+			verbose = true
+			args = args[1:]
+		} else if args[0] == "-out" && len(args) >= 2 {
+			n, err := parsePortNumber(args[1])
+			if err != nil {
+				return err
+			}
+			outPortNumber = &n
+			args = args[2:]
+		} else {
+			break
+		}
+	}
 	if len(args) != 2 {
 		return errUsage
 	}
@@ -29,7 +48,7 @@ func mapCommandModifier(args []string) error {
 		return err
 	}
 
-	mappings, err := getMappingsFromMapName(mapName)
+	m, err := getMapFromMapName(mapName)
 	if err != nil {
 		return err
 	}
@@ -64,71 +83,129 @@ func mapCommandModifier(args []string) error {
 		return err
 	}
 
+	e := newEngine(&kb, m)
+	e.verbose = verbose
+	if outPortNumber != nil {
+		outs, err := drv.Outs()
+		if err != nil {
+			return err
+		}
+		out, err := getOutByPortNumber(outs, *outPortNumber)
+		if err != nil {
+			return err
+		}
+		if err := out.Open(); err != nil {
+			return err
+		}
+		defer out.Close()
+		e.setOutput(out)
+	}
+	go watchMap(mapName, m, e)
 	rd := reader.New(
 		reader.NoLogger(),
 		reader.Each(func(pos *reader.Position, msg midi.Message) {
-			err := mapMIDIMessageToKeyPress(kb, mappings, msg)
+			err := e.mapMIDIMessageToKeyPress(msg)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 			}
 		}),
 	)
 
-	// I don't understand how and why this snippet works, but it does.
-	// I asked for an explanation in https://github.com/vipul-sharma20/midi-macro/issues/1.
-	// TODO: Read chapter 8 in gopl, which hopefully explains this
-	exit := make(chan string)
-
 	go rd.ListenTo(in)
 
-	for {
-		select {
-		case <-exit:
-			return nil
-		}
-	}
+	// Run until interrupted, and then stop performing macros and release the held keys before exiting.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	e.close()
+	return nil
 }
 
-func mapMIDIMessageToKeyPress(kb keybd_event.KeyBonding, mappings []mapping.Mapping, msg midi.Message) (err error) {
-	for _, mapping := range mappings {
-		if matcherMatchesMessage(mapping.Matcher, msg) {
-			// NB: We iterate through all mappings regardless of if some earlier mapping matched. This is expected behaviour.
-			err = press(kb, mapping.Keycode)
-			if err != nil {
-				break
-			}
-		}
+// getMapFromMapName parses a midimap-lang file with a name of mapName, along with the files it includes.
+// If an io-error occurs, the error is returned.
+// If the parser fails at parsing some lines, it describes the problems and returns a map of the remaining lines.
+// No error is returned for parsing errors, so that a map can be used even though some of its lines are mistyped.
+func getMapFromMapName(mapName string) (m lang.Map, err error) {
+	m, err = lang.ParseFile(mapName)
+	if errs, ok := err.(lang.ErrorList); ok {
+		printErrors(errs)
+		err = nil
 	}
 	return
 }
 
-// getMappingsFromMapName parses a midimap-lang file with a name of mapName and retrieves its mappings.
-// If an io-error occurs, the error is returned.
-// If the parser fails at parsing some mapping, it describe the problem and moves on to the next mapping.
-// No error is returned for parsing errors.
-func getMappingsFromMapName(mapName string) (mappings []mapping.Mapping, err error) {
-	mapFile, err := os.Open(mapName)
-	if err != nil {
-		return
+// printErrors describes the lines of a map which could not be parsed.
+func printErrors(errs lang.ErrorList) {
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "lang: %v\n", e)
 	}
-	r := bufio.NewReader(mapFile)
-	for {
-		mapping, err := lang.NextMapping(r)
+}
 
-		if err == io.EOF {
-			break
+// watchInterval is how often watchMap checks whether the files of a map have changed.
+const watchInterval = time.Second
+
+// watchMap reloads the map with a name of mapName into e whenever one of the files it was read from changes, m being
+// the map which e was created with. It never returns.
+//
+// Unlike getMapFromMapName, watchMap does not reload a map which has lines that cannot be parsed, as they may be
+// mappings which keys are held by. e keeps the map it has until the files are changed again.
+func watchMap(mapName string, m lang.Map, e *engine) {
+	files := m.Files
+	modified := modificationTimes(files)
+	for range time.Tick(watchInterval) {
+		if reflect.DeepEqual(modificationTimes(files), modified) {
+			continue
+		}
+		newM, err := lang.ParseFile(mapName)
+		if errs, ok := err.(lang.ErrorList); ok {
+			printErrors(errs)
+			fmt.Fprintf(os.Stderr, "reload: %s not reloaded\n", mapName)
+			// The files may have changed to include others, which a fix may then be made to.
+			files = newM.Files
+			modified = modificationTimes(files)
+			continue
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "lang: %v\n", err)
+			// The file may be in the middle of being written, so it is tried once more at the next tick.
+			fmt.Fprintf(os.Stderr, "reload: %v\n", err)
+			continue
 		}
-		mappings = append(mappings, mapping)
+		// The files may have changed to include others.
+		files = newM.Files
+		modified = modificationTimes(files)
+		e.reload(newM)
+		fmt.Fprintf(os.Stderr, "reloaded %s\n", mapName)
 	}
-	return
 }
 
-// press simulates pressing k on kb.
-func press(kb keybd_event.KeyBonding, k int) (err error) {
-	kb.SetKeys(k)
+// modificationTimes returns the modification times of the files named names, with the zero time for those which cannot
+// be accessed.
+func modificationTimes(names []string) []time.Time {
+	times := make([]time.Time, len(names))
+	for i, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			times[i] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// keyboard simulates key presses, as *keybd_event.KeyBonding does.
+type keyboard interface {
+	SetKeys(keys ...int)
+	HasCTRL(bool)
+	HasSHIFT(bool)
+	HasALT(bool)
+	HasSuper(bool)
+	Launching() error
+	Press() error
+	Release() error
+	Clear()
+}
+
+// press simulates pressing the key of a on kb.
+func press(kb keyboard, a action.KeyAction) (err error) {
+	setKey(kb, a)
 	err = kb.Launching()
 	if err != nil {
 		return
@@ -136,3 +213,28 @@ func press(kb keybd_event.KeyBonding, k int) (err error) {
 	kb.Clear()
 	return
 }
+
+// pressDown presses the key of a on kb without releasing it.
+func pressDown(kb keyboard, a action.KeyAction) (err error) {
+	setKey(kb, a)
+	err = kb.Press()
+	kb.Clear()
+	return
+}
+
+// release releases the key of a on kb.
+func release(kb keyboard, a action.KeyAction) (err error) {
+	setKey(kb, a)
+	err = kb.Release()
+	kb.Clear()
+	return
+}
+
+// setKey sets the key of kb to the key of a, along with its modifiers.
+func setKey(kb keyboard, a action.KeyAction) {
+	kb.SetKeys(a.Keycode)
+	kb.HasCTRL(a.Modifiers&action.CtrlModifier != 0)
+	kb.HasSHIFT(a.Modifiers&action.ShiftModifier != 0)
+	kb.HasALT(a.Modifiers&action.AltModifier != 0)
+	kb.HasSuper(a.Modifiers&action.SuperModifier != 0)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// matcherMatchesMessage reports whether m matches msg.
+func (e *engine) matcherMatchesMessage(m matcher.Matcher, msg midi.Message) bool {
+	switch m := m.(type) {
+	case matcher.MatcherWithoutLogicalOperator:
+		data, ok := e.operandValue(m.LeftOperand, msg)
+		if !ok {
+			return false
+		}
+
+		switch m.Operator {
+		case matcher.LessThanOperator:
+			return data < m.RightOperand
+		case matcher.LessThanOrEqualToOperator:
+			return data <= m.RightOperand
+		case matcher.EqualToOperator:
+			return data == m.RightOperand
+		case matcher.UnequalToOperator:
+			return data != m.RightOperand
+		case matcher.GreaterThanOrEqualToOperator:
+			return data >= m.RightOperand
+		case matcher.GreaterThanOperator:
+			return data > m.RightOperand
+		default:
+			panic("unreachable")
+		}
+	case matcher.MatcherWithMembershipOperator:
+		data, ok := e.operandValue(m.LeftOperand, msg)
+		if !ok {
+			return false
+		}
+
+		in := false
+		for _, r := range m.Set {
+			if r.Contains(data) {
+				in = true
+				break
+			}
+		}
+		switch m.Operator {
+		case matcher.InOperator:
+			return in
+		case matcher.NotInOperator:
+			return !in
+		default:
+			panic("unreachable")
+		}
+	case matcher.MatcherWithLogicalOperator:
+		switch m.Operator {
+		case matcher.LogicalAndOperator:
+			return e.matcherMatchesMessage(m.LeftMatcher, msg) &&
+				e.matcherMatchesMessage(m.RightMatcher, msg)
+		case matcher.LogicalOrOperator:
+			return e.matcherMatchesMessage(m.LeftMatcher, msg) ||
+				e.matcherMatchesMessage(m.RightMatcher, msg)
+		default:
+			panic("unreachable")
+		}
+	case matcher.ChordMatcher:
+		return e.chordCompletes(m, msg)
+	case matcher.TapsMatcher:
+		return e.tapsMatch(m, msg)
+	case matcher.CrossingMatcher:
+		return e.crossingMatches(m, msg)
+	case matcher.RateMatcher:
+		return e.rateMatches(m, time.Now())
+	default:
+		panic("unreachable")
+	}
+}
+
+// expressionValue returns the value of x for msg, or an error if an operand of x has no value for msg or x divides by
+// zero.
+func (e *engine) expressionValue(x matcher.Expression, msg midi.Message) (int64, error) {
+	switch x := x.(type) {
+	case matcher.Constant:
+		return x.Value, nil
+	case matcher.OperandExpression:
+		n, ok := e.operandValue(x.Operand, msg)
+		if !ok {
+			return 0, fmt.Errorf("expression: no value for message % X", msg.Raw())
+		}
+		return n, nil
+	case matcher.BinaryExpression:
+		left, err := e.expressionValue(x.Left, msg)
+		if err != nil {
+			return 0, err
+		}
+		right, err := e.expressionValue(x.Right, msg)
+		if err != nil {
+			return 0, err
+		}
+		n, ok := x.Operator.Apply(left, right)
+		if !ok {
+			return 0, fmt.Errorf("expression: division by zero")
+		}
+		return n, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+// operandValue returns the value of o for msg, and whether o has a value for msg at all.
+func (e *engine) operandValue(o matcher.Operand, msg midi.Message) (int64, bool) {
+	switch o := o.(type) {
+	case matcher.MessageByte:
+		switch o {
+		case matcher.Pad:
+			n, _, ok := noteOf(msg)
+			return int64(n.key), ok
+		case matcher.Control:
+			raw := msg.Raw()
+			if len(raw) < 2 || raw[0]&0xf0 != 0xb0 {
+				return 0, false
+			}
+			return int64(raw[1]), true
+		}
+		// msg.Raw()[0] is status
+		// msg.Raw()[1] is data1
+		// msg.Raw()[2] is data2
+		var i int
+		switch o {
+		case matcher.Status:
+			i = 0
+		case matcher.Data1:
+			i = 1
+		case matcher.Data2:
+			i = 2
+		default:
+			panic("unreachable")
+		}
+		// Some messages, such as program changes, lack data2 or even data1.
+		if i >= len(msg.Raw()) {
+			return 0, false
+		}
+		return int64(msg.Raw()[i]), true
+	case matcher.Variable:
+		return e.variables[o.Name], true
+	case matcher.Duration:
+		d, ok := e.durationValue(o, msg)
+		return d.Milliseconds(), ok
+	case matcher.ControllerChange:
+		return e.controllerChangeValue(o, msg)
+	default:
+		panic("unreachable")
+	}
+}
@@ -30,15 +30,18 @@ func mainish() error {
 		return mapCommandModifier(os.Args[2:])
 	case "log":
 		return logCommandModifier(os.Args[2:])
+	case "reverse":
+		return reverseCommandModifier(os.Args[2:])
 	default:
 		return errUsage
 	}
 }
 
 var errUsage = errors.New(strings.TrimSpace(`
-usage:	midimap ports
-	midimap map portnumber mapname
-	midimap log portnumber [matcher]`))
+usage:	midimap ports [-out]
+	midimap map [-verbose] [-out portnumber] portnumber mapname
+	midimap log [-device profile] portnumber [matcher]
+	midimap reverse [-grab] inputdevice portnumber reversemapname`))
 
 // 	midimap map portnumber mapname
 //	midimap log portnumber [matcher]`))
@@ -13,7 +13,8 @@ import (
 // For documentation about the ports command modifier itself, consult
 // midimap(1).
 func portsCommandModifier(args []string) error {
-	if len(args) != 0 {
+	out := len(args) == 1 && args[0] == "-out"
+	if len(args) != 0 && !out {
 		return errUsage
 	}
 
@@ -23,6 +24,15 @@ func portsCommandModifier(args []string) error {
 	}
 	defer drv.Close()
 
+	if out {
+		outs, err := drv.Outs()
+		if err != nil {
+			return err
+		}
+		printOuts(outs)
+		return nil
+	}
+
 	ins, err := drv.Ins()
 	if err != nil {
 		return err
@@ -37,3 +47,9 @@ func printIns(ins []midi.In) {
 		fmt.Printf("%d\t%s\n", in.Number(), in.String())
 	}
 }
+
+func printOuts(outs []midi.Out) {
+	for _, out := range outs {
+		fmt.Printf("%d\t%s\n", out.Number(), out.String())
+	}
+}
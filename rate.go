@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// sameRate reports whether the same messages are counted for r and s, as they are for every rate matcher with the same
+// matcher and duration.
+func sameRate(r, s matcher.RateMatcher) bool {
+	return r.Matcher.Equal(s.Matcher) && r.Per == s.Per
+}
+
+// rateIndex returns the index of the rate matcher of the map for which the messages of r are counted, or -1 if there is
+// none.
+func (e *engine) rateIndex(r matcher.RateMatcher) int {
+	for i, s := range e.rates {
+		if sameRate(r, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// trackRates counts msg, at now, for every rate matcher of the map whose matcher matches it.
+// This is done for every message, rather than when the rate matchers are evaluated, as they may not be evaluated for
+// every message.
+func (e *engine) trackRates(msg midi.Message, now time.Time) {
+	e.pruneRates(now)
+	for i, r := range e.rates {
+		if e.matcherMatchesMessage(r.Matcher, msg) {
+			e.rateHits[i] = append(e.rateHits[i], now)
+		}
+	}
+}
+
+// pruneRates stops counting the messages which are no longer within the duration of their rate matchers at now.
+func (e *engine) pruneRates(now time.Time) {
+	for i, r := range e.rates {
+		hits := e.rateHits[i]
+		for len(hits) > 0 && now.Sub(hits[0]) >= r.Per {
+			hits = hits[1:]
+		}
+		e.rateHits[i] = hits
+	}
+}
+
+// rateMatches reports whether r matches at now.
+func (e *engine) rateMatches(r matcher.RateMatcher, now time.Time) bool {
+	var count int64
+	if i := e.rateIndex(r); i != -1 {
+		for _, t := range e.rateHits[i] {
+			if now.Sub(t) < r.Per {
+				count++
+			}
+		}
+	}
+	switch r.Operator {
+	case matcher.LessThanOperator:
+		return count < r.Count
+	case matcher.LessThanOrEqualToOperator:
+		return count <= r.Count
+	case matcher.EqualToOperator:
+		return count == r.Count
+	case matcher.UnequalToOperator:
+		return count != r.Count
+	case matcher.GreaterThanOrEqualToOperator:
+		return count >= r.Count
+	case matcher.GreaterThanOperator:
+		return count > r.Count
+	default:
+		panic("unreachable")
+	}
+}
+
+// scheduleRateExpiry schedules releasing the keys held by mappings with rate matchers which no longer match, for when
+// the next counted message is no longer within the duration of its rate matcher, as long as any such key is held.
+func (e *engine) scheduleRateExpiry(now time.Time) {
+	if e.rateTimer != nil {
+		e.rateTimer.Stop()
+		e.rateTimer = nil
+	}
+	held := false
+	for _, h := range e.keyHolds {
+		held = held || hasRateMatcher(h.mapping.Matcher)
+	}
+	if !held {
+		return
+	}
+
+	e.pruneRates(now)
+	var next time.Duration
+	for i, r := range e.rates {
+		hits := e.rateHits[i]
+		if len(hits) == 0 {
+			continue
+		}
+		if d := r.Per - now.Sub(hits[0]); next == 0 || d < next {
+			next = d
+		}
+	}
+	if next == 0 {
+		return
+	}
+	e.rateTimer = time.AfterFunc(next, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.report(e.releaseKeys(nil))
+		e.scheduleRateExpiry(time.Now())
+	})
+}
+
+func (e *engine) addRate(r matcher.RateMatcher) {
+	if e.rateIndex(r) != -1 {
+		return
+	}
+	e.rates = append(e.rates, r)
+	e.rateHits = append(e.rateHits, nil)
+}
+
+// hasRateMatcher reports whether m is or is composed of a rate matcher.
+func hasRateMatcher(m matcher.Matcher) (has bool) {
+	matcher.Walk(m, func(n matcher.Matcher) {
+		if _, ok := n.(matcher.RateMatcher); ok {
+			has = true
+		}
+	})
+	return
+}
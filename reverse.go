@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fossegrim/midimap/lang/reverse"
+)
+
+// reverseCommandModifier corresponds to the reverse command modifier. args
+// corresponds to the list of arguments listed on the command line after the
+// reverse command modifier.
+//
+// For documentation about the reverse command modifier itself, see midimap(1).
+func reverseCommandModifier(args []string) error {
+	var grab bool
+	if len(args) >= 1 && args[0] == "-grab" {
+		grab = true
+		args = args[1:]
+	}
+	if len(args) != 3 {
+		return errUsage
+	}
+	inputDevice := args[0]
+	portNumber, err := parsePortNumber(args[1])
+	if err != nil {
+		return err
+	}
+
+	m, err := reverse.ParseFile(args[2])
+	if err != nil {
+		return err
+	}
+
+	drv, err := newDriver()
+	if err != nil {
+		return err
+	}
+	defer drv.Close()
+
+	outs, err := drv.Outs()
+	if err != nil {
+		return err
+	}
+
+	out, err := getOutByPortNumber(outs, portNumber)
+	if err != nil {
+		return err
+	}
+
+	err = out.Open()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Run until interrupted or the input device can no longer be read, as when it is unplugged.
+	done := make(chan error, 1)
+	go func() {
+		done <- readKeys(inputDevice, grab, func(keycode int, pressed bool) {
+			for _, msg := range m.Messages(keycode) {
+				raw := msg.Off()
+				if pressed {
+					raw = msg.On()
+				}
+				if _, err := out.Write(raw); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
+			}
+		})
+	}()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-done:
+		return err
+	case <-interrupt:
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/fossegrim/midimap/lang"
+	"github.com/fossegrim/midimap/lang/action"
+	"gitlab.com/gomidi/midi"
+)
+
+// scroll scrolls the mouse wheel as described by a, which is performed in response to msg.
+// The wheel is a virtual input device, which is created by openWheel when the map is loaded.
+func (e *engine) scroll(a action.ScrollAction, msg midi.Message) error {
+	n, err := e.expressionValue(a.Amount, msg)
+	if err != nil || n == 0 {
+		return err
+	}
+	if e.wheel == nil {
+		// Creating it failed, which has been reported already.
+		return errors.New("scroll: no virtual mouse wheel")
+	}
+	code := uint16(relWheel)
+	if a.Horizontal {
+		code = relHWheel
+	}
+	return e.wheel.send(evRel, code, int32(n))
+}
+
+// openWheel creates the virtual mouse wheel of e if an action of m scrolls it, unless it has been created already.
+// It must not be called with e.mu held.
+func (e *engine) openWheel(m lang.Map) error {
+	e.mu.Lock()
+	open := e.wheel != nil
+	e.mu.Unlock()
+	if open || !usesAction(m, isScrollAction) {
+		return nil
+	}
+	w, err := newUinputDevice("midimap wheel", map[uint16][]uint16{evRel: {relWheel, relHWheel}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.wheel != nil {
+		// Another map was loaded meanwhile, creating the wheel too.
+		return w.close()
+	}
+	e.wheel = w
+	return nil
+}
+
+func isScrollAction(a action.Action) bool {
+	_, ok := a.(action.ScrollAction)
+	return ok
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fossegrim/midimap/lang/mapping"
+	"gitlab.com/gomidi/midi"
+)
+
+// sequenceProgress is a partial match of a sequence, which started at start and is awaiting a message matching the
+// matcher with an index of next in the sequence. If next is the length of the sequence, it is awaiting a message
+// matching the matcher of the mapping, which completes the sequence.
+type sequenceProgress struct {
+	start time.Time
+	next  int
+	// kinds are the kinds of the messages matched so far, as returned by messageKind.
+	kinds uint16
+}
+
+// completeSequence reports whether the sequence mp is awaiting the message completing it at now, in which case its
+// partial matches are discarded, so that the sequence completes only once.
+// It must only be called for a message which the matcher of mp matches.
+func (e *engine) completeSequence(mp *mapping.Mapping, now time.Time) bool {
+	for _, p := range e.progress[mp] {
+		if p.next == len(mp.Sequence) && now.Sub(p.start) <= mp.Within {
+			delete(e.progress, mp)
+			return true
+		}
+	}
+	return false
+}
+
+// advanceSequences advances the partial matches of every sequence, as msg arrives at now.
+//
+// Every partial match which is not completed within its Within, or whose next matcher does not match a message of a
+// kind it has matched before, is discarded. Messages of other kinds, such as the control changes of a hi-hat pedal or
+// the aftertouch sent between the notes of a sequence, never discard partial matches, and neither do releases, that is
+// note offs, or system messages, such as clock.
+// A new partial match is started for every message matching the first matcher of a sequence, so that sequences sharing a
+// prefix with themselves are matched however the prefix is repeated, such as a sequence of two kicks followed by a
+// snare by three kicks followed by a snare. Sequences progress independently of each other, so a sequence being the
+// prefix of another sequence does not keep the other sequence from completing.
+func (e *engine) advanceSequences(msg midi.Message, now time.Time) {
+	_, on, isNote := noteOf(msg)
+	kind := messageKind(msg)
+	ignored := isNote && !on || kind == 0
+	for _, mp := range e.sequences {
+		var progress []sequenceProgress
+		for _, p := range e.progress[mp] {
+			if now.Sub(p.start) > mp.Within {
+				continue
+			}
+			switch {
+			case ignored:
+				progress = append(progress, p)
+			case p.next < len(mp.Sequence) && e.matcherMatchesMessage(mp.Sequence[p.next], msg):
+				progress = append(progress, sequenceProgress{p.start, p.next + 1, p.kinds | kind})
+			case p.kinds&kind == 0:
+				progress = append(progress, p)
+			}
+		}
+		if !ignored && e.matcherMatchesMessage(mp.Sequence[0], msg) {
+			progress = append(progress, sequenceProgress{now, 1, kind})
+		}
+		e.progress[mp] = progress
+	}
+}
+
+// messageKind returns the kind of msg, such as note on or control change, as a bit indexed by the upper four bits of its
+// status, or 0 if msg is a system message.
+func messageKind(msg midi.Message) uint16 {
+	raw := msg.Raw()
+	if len(raw) == 0 || raw[0] >= 0xf0 {
+		return 0
+	}
+	return 1 << (raw[0] >> 4)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fossegrim/midimap/lang/matcher"
+	"gitlab.com/gomidi/midi"
+)
+
+// tapKey identifies the taps of a note counted for the taps matchers with a Within of within.
+type tapKey struct {
+	note   note
+	within time.Duration
+}
+
+// tapCounter counts the taps of a note.
+type tapCounter struct {
+	count int64
+	// last is the time of the last tap, and msg the note on of it.
+	last  time.Time
+	msg   midi.Message
+	timer *time.Timer
+}
+
+// trackTaps counts msg, if it is a note on, as a tap at now for every duration which taps matchers count taps within.
+func (e *engine) trackTaps(msg midi.Message, now time.Time) {
+	n, on, ok := noteOf(msg)
+	if !ok || !on {
+		return
+	}
+	for _, within := range e.tapWindows {
+		k := tapKey{n, within}
+		c, ok := e.taps[k]
+		if !ok || now.Sub(c.last) > within {
+			c = &tapCounter{}
+			e.taps[k] = c
+		}
+		c.count++
+		c.last = now
+		c.msg = msg
+	}
+}
+
+// scheduleTapsExpiry schedules the evaluation of msg, if it is a note on, for when each duration which taps matchers
+// count taps within has passed without another tap of the note, unless it is followed by another tap before.
+// At those evaluations, only the mappings with a taps matcher are evaluated, and the taps are no longer counted
+// afterwards.
+func (e *engine) scheduleTapsExpiry(msg midi.Message) {
+	n, on, ok := noteOf(msg)
+	if !ok || !on {
+		return
+	}
+	for _, within := range e.tapWindows {
+		k := tapKey{n, within}
+		c := e.taps[k]
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		last := c.last
+		c.timer = time.AfterFunc(within, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if e.taps[k] != c || c.last != last {
+				// The taps were followed by another tap since.
+				return
+			}
+			e.expiredTaps = &k
+			e.report(e.evaluate(c.msg, hasTapsMatcher))
+			e.expiredTaps = nil
+			delete(e.taps, k)
+		})
+	}
+}
+
+// tapsMatch reports whether t matches msg.
+func (e *engine) tapsMatch(t matcher.TapsMatcher, msg midi.Message) bool {
+	n, on, ok := noteOf(msg)
+	if !ok || !on {
+		return false
+	}
+	k := tapKey{n, t.Within}
+	c, ok := e.taps[k]
+	if !ok || c.count != t.Count {
+		return false
+	}
+	if t.Immediate {
+		return e.expiredTaps == nil
+	}
+	return e.expiredTaps != nil && *e.expiredTaps == k
+}
+
+func (e *engine) addTapWindow(within time.Duration) {
+	for _, w := range e.tapWindows {
+		if w == within {
+			return
+		}
+	}
+	e.tapWindows = append(e.tapWindows, within)
+}
+
+// hasTapsMatcher reports whether m is or is composed of a taps matcher.
+func hasTapsMatcher(m matcher.Matcher) (has bool) {
+	matcher.Walk(m, func(n matcher.Matcher) {
+		if _, ok := n.(matcher.TapsMatcher); ok {
+			has = true
+		}
+	})
+	return
+}
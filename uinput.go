@@ -0,0 +1,18 @@
+package main
+
+// The constants of linux/input-event-codes.h which are used by virtual input devices.
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+
+	synReport = 0
+
+	relHWheel = 0x06
+	relWheel  = 0x08
+
+	absX = 0x00
+
+	btnSouth = 0x130
+)
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// The constants of linux/uinput.h which are used.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetAbsBit  = 0x40045567
+
+	absCnt = 0x40
+)
+
+// uinputDevice is a virtual input device created with uinput(4).
+type uinputDevice struct {
+	f *os.File
+}
+
+// uinputUserDev is struct uinput_user_dev, which describes a device to uinput.
+type uinputUserDev struct {
+	Name         [80]byte
+	ID           struct{ Bustype, Vendor, Product, Version uint16 }
+	FFEffectsMax uint32
+	AbsMax       [absCnt]int32
+	AbsMin       [absCnt]int32
+	AbsFuzz      [absCnt]int32
+	AbsFlat      [absCnt]int32
+}
+
+// inputEvent is struct input_event, which is an event of a device.
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// uinputSetBits are the ioctls enabling the codes of each type of event.
+var uinputSetBits = map[uint16]uintptr{
+	evKey: uiSetKeyBit,
+	evRel: uiSetRelBit,
+	evAbs: uiSetAbsBit,
+}
+
+// newUinputDevice creates a virtual input device named name, which sends events of the given codes by type.
+// The absolute axes, that is the codes of type evAbs, range from the value of absMin to the value of absMax by code.
+func newUinputDevice(name string, codes map[uint16][]uint16, absMin, absMax map[uint16]int32) (*uinputDevice, error) {
+	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("insufficient permissions to create %s", name)
+		}
+		return nil, err
+	}
+	d := &uinputDevice{f}
+
+	var dev uinputUserDev
+	copy(dev.Name[:len(dev.Name)-1], name)
+	dev.ID.Bustype = 0x03 // BUS_USB
+	dev.ID.Vendor = 0x1
+	dev.ID.Product = 0x1
+	dev.ID.Version = 1
+	for code, n := range absMin {
+		dev.AbsMin[code] = n
+	}
+	for code, n := range absMax {
+		dev.AbsMax[code] = n
+	}
+	for typ, cs := range codes {
+		if err := d.ioctl(uiSetEvBit, uintptr(typ)); err != nil {
+			f.Close()
+			return nil, err
+		}
+		for _, code := range cs {
+			if err := d.ioctl(uinputSetBits[typ], uintptr(code)); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, dev)
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := d.ioctl(uiDevCreate, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Programs take a while to notice new devices, and miss the events sent before they do.
+	time.Sleep(200 * time.Millisecond)
+	return d, nil
+}
+
+func (d *uinputDevice) ioctl(request, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// send sends an event of type typ, with a code of code and a value of value, followed by a report, which delivers it.
+func (d *uinputDevice) send(typ, code uint16, value int32) error {
+	var buf bytes.Buffer
+	for _, ev := range []inputEvent{{Type: typ, Code: code, Value: value}, {Type: evSyn, Code: synReport}} {
+		binary.Write(&buf, binary.LittleEndian, ev)
+	}
+	_, err := d.f.Write(buf.Bytes())
+	return err
+}
+
+// close destroys d.
+func (d *uinputDevice) close() error {
+	d.ioctl(uiDevDestroy, 0)
+	return d.f.Close()
+}
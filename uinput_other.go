@@ -0,0 +1,20 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+// uinputDevice is a virtual input device, which is only supported on Linux.
+type uinputDevice struct{}
+
+func newUinputDevice(name string, codes map[uint16][]uint16, absMin, absMax map[uint16]int32) (*uinputDevice, error) {
+	return nil, errors.New("virtual input devices are only supported on Linux")
+}
+
+func (d *uinputDevice) send(typ, code uint16, value int32) error {
+	panic("unreachable")
+}
+
+func (d *uinputDevice) close() error {
+	panic("unreachable")
+}